@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// readBatchFile reads book IDs from a file, one per line. Blank lines and
+// lines starting with # are ignored.
+func readBatchFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading batch file: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading batch file: %w", err)
+	}
+	return ids, nil
+}
+
+// Outcomes of the books of a batch
+const (
+	batchNotStarted = "not downloaded"
+	batchSkipped    = "skipped, already in index"
+	batchDownloaded = "downloaded"
+	batchFailed     = "failed"
+)
+
+// runBatch downloads the books in ids, up to parallel at a time, and prints
+// the outcome of each at the end. Books that are already in the index as
+// each of docTypes with their output still on disk are skipped unless
+// reindex is set. Books not yet started are skipped once ctx is cancelled.
+func runBatch(ctx context.Context, ids []string, docTypes []string, reindex bool, parallel int, download func(id string) bool) {
+	status := make([]string, len(ids))
+	for i := range status {
+		status[i] = batchNotStarted
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(parallel, 1))
+	for i, id := range ids {
+		// Wait for a free slot before starting the next book
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			fmt.Printf("Batch interrupted, %d of %d books not downloaded\n", len(ids)-i, len(ids))
+			break
+		}
+		fmt.Printf("[%d/%d] Book %s\n", i+1, len(ids), id)
+
+		if !reindex {
+			var paths []string
+			for _, docType := range docTypes {
+				if entry, ok := findIndexEntry(id, docType); ok {
+					paths = append(paths, entry.Path)
+				}
+			}
+			if len(paths) == len(docTypes) {
+				fmt.Printf("Skipping %s: already in index at %s\n", id, strings.Join(paths, ", "))
+				status[i] = batchSkipped
+				<-slots
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if download(id) {
+				status[i] = batchDownloaded
+			} else {
+				status[i] = batchFailed
+			}
+		}()
+	}
+	wg.Wait()
+	printBatchSummary(os.Stdout, ids, status)
+}
+
+// printBatchSummary lists the outcome of every book of a batch
+func printBatchSummary(w io.Writer, ids, status []string) {
+	counts := make(map[string]int)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Batch summary:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, id := range ids {
+		fmt.Fprintf(tw, "  %s\t%s\n", id, status[i])
+		counts[status[i]]++
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d downloaded, %d failed, %d skipped, %d not downloaded\n",
+		counts[batchDownloaded], counts[batchFailed], counts[batchSkipped], counts[batchNotStarted])
+}
+
+// linePrefixWriter prefixes every line written to it, so that the output of
+// books downloaded at the same time can be told apart. Writers sharing mu
+// write whole lines to w without interleaving.
+type linePrefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte // incomplete last line
+}
+
+func (p *linePrefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(data), nil
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p.buf[:i+1], []byte("\n")) {
+		if len(line) > 0 {
+			out.WriteString(p.prefix)
+			out.Write(line)
+		}
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// flush writes an incomplete last line
+func (p *linePrefixWriter) flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+)
+
+// SSIM constants for 8-bit images, see Wang et al. (2004)
+const (
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+	ssimWindow = 8 // window size in pixels
+	ssimStep   = 4 // distance between windows
+)
+
+// diffScale amplifies differences in the difference image so that small
+// deviations are visible
+const diffScale = 4
+
+// runCompare compares two images of the same page, e.g. downloaded at
+// different widths, and writes a side-by-side difference image
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	pathA := fs.String("page-a", "", "First page image")
+	pathB := fs.String("page-b", "", "Second page image")
+	out := fs.String("out", "compare.png", "Side-by-side difference image to write")
+	fs.Parse(args)
+
+	if *pathA == "" || *pathB == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the images to compare with -page-a and -page-b")
+		return 1
+	}
+
+	imgA, err := loadImage(*pathA)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	imgB, err := loadImage(*pathB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// The larger image is scaled down, since detail that only one of them
+	// has cannot be compared
+	sizeA, sizeB := imgA.Bounds().Size(), imgB.Bounds().Size()
+	w, h := min(sizeA.X, sizeB.X), min(sizeA.Y, sizeB.Y)
+	a, b := resizeGray(imgA, w, h), resizeGray(imgB, w, h)
+
+	fmt.Printf("Page A: %dx%d  %s\n", sizeA.X, sizeA.Y, *pathA)
+	fmt.Printf("Page B: %dx%d  %s\n", sizeB.X, sizeB.Y, *pathB)
+	fmt.Printf("Compared at %dx%d\n", w, h)
+	mse := meanSquaredError(a, b)
+	fmt.Printf("MSE:  %.2f\n", mse)
+	if mse > 0 {
+		fmt.Printf("PSNR: %.2f dB\n", 10*math.Log10(255*255/mse))
+	}
+	fmt.Printf("SSIM: %.4f (1 means identical)\n", ssim(a, b))
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing difference image:", err)
+		return 1
+	}
+	defer f.Close()
+	if err := png.Encode(f, sideBySide(a, b)); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing difference image:", err)
+		return 1
+	}
+	fmt.Println("Difference image saved to", *out)
+	return 0
+}
+
+// loadImage decodes a JPEG or PNG file
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// resizeGray converts an image to grayscale and scales it to w×h pixels.
+// Each target pixel is the average of the source pixels it covers, which
+// avoids aliasing when scaling down.
+func resizeGray(img image.Image, w, h int) *image.Gray {
+	bounds := img.Bounds()
+	src := image.NewGray(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/h, y0+1)
+		for x := range w {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/w, x0+1)
+
+			var sum, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += int(src.GrayAt(sx, sy).Y)
+					n++
+				}
+			}
+			dst.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+		}
+	}
+	return dst
+}
+
+// meanSquaredError returns the mean squared difference of two images of the
+// same size
+func meanSquaredError(a, b *image.Gray) float64 {
+	var sum float64
+	for i := range a.Pix {
+		d := float64(a.Pix[i]) - float64(b.Pix[i])
+		sum += d * d
+	}
+	return sum / float64(len(a.Pix))
+}
+
+// ssim returns the mean structural similarity of two images of the same
+// size, computed over ssimWindow×ssimWindow windows every ssimStep pixels.
+// Images smaller than a window are compared as a whole.
+func ssim(a, b *image.Gray) float64 {
+	bounds := a.Bounds()
+	win := min(ssimWindow, bounds.Dx(), bounds.Dy())
+
+	var total float64
+	var windows int
+	for y := bounds.Min.Y; y+win <= bounds.Max.Y; y += ssimStep {
+		for x := bounds.Min.X; x+win <= bounds.Max.X; x += ssimStep {
+			total += windowSSIM(a, b, image.Rect(x, y, x+win, y+win))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+// windowSSIM computes the SSIM of one window
+func windowSSIM(a, b *image.Gray, r image.Rectangle) float64 {
+	n := float64(r.Dx() * r.Dy())
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			va, vb := float64(a.GrayAt(x, y).Y), float64(b.GrayAt(x, y).Y)
+			sumA += va
+			sumB += vb
+			sumAA += va * va
+			sumBB += vb * vb
+			sumAB += va * vb
+		}
+	}
+	meanA, meanB := sumA/n, sumB/n
+	varA := sumAA/n - meanA*meanA
+	varB := sumBB/n - meanB*meanB
+	cov := sumAB/n - meanA*meanB
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// sideBySide places the two images and their difference next to each other.
+// In the difference, white means identical and darker pixels larger
+// differences, amplified by diffScale.
+func sideBySide(a, b *image.Gray) *image.Gray {
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	out := image.NewGray(image.Rect(0, 0, 3*w, h))
+	draw.Draw(out, image.Rect(0, 0, w, h), a, image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(w, 0, 2*w, h), b, image.Point{}, draw.Src)
+	for y := range h {
+		for x := range w {
+			d := math.Abs(float64(a.GrayAt(x, y).Y) - float64(b.GrayAt(x, y).Y))
+			out.SetGray(2*w+x, y, color.Gray{Y: uint8(255 - min(d*diffScale, 255))})
+		}
+	}
+	return out
+}
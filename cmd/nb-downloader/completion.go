@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// The completion scripts read the sub-commands and flags from the -h output
+// of nb-downloader itself, so they stay in sync with the installed version.
+// Only the values of -type and -format are written into the scripts.
+
+const bashCompletion = `# bash completion for nb-downloader
+_nb_downloader() {
+    local cur prev cmd
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    cmd=""
+    if [[ ${COMP_CWORD} -gt 1 && "${COMP_WORDS[1]}" != -* ]]; then
+        cmd="${COMP_WORDS[1]}"
+    fi
+
+    case "${prev#-}" in
+    -type|type)
+        COMPREPLY=($(compgen -W "{{types}}" -- "$cur"))
+        return ;;
+    -format|format)
+        case "$cmd" in
+        urls) COMPREPLY=($(compgen -W "plain tsv" -- "$cur")) ;;
+        export) COMPREPLY=($(compgen -W "bibtex ris" -- "$cur")) ;;
+        marc) COMPREPLY=($(compgen -W "iso2709 xml" -- "$cur")) ;;
+        wordfreq) COMPREPLY=($(compgen -W "csv json" -- "$cur")) ;;
+        *) COMPREPLY=($(compgen -W "{{formats}}" -- "$cur")) ;;
+        esac
+        return ;;
+    -color-space|color-space)
+        COMPREPLY=($(compgen -W "rgb gray cmyk" -- "$cur"))
+        return ;;
+    -image-format|image-format)
+        COMPREPLY=($(compgen -W "jpg png" -- "$cur"))
+        return ;;
+    -on-conflict|on-conflict)
+        COMPREPLY=($(compgen -W "overwrite skip rename error" -- "$cur"))
+        return ;;
+    -from-browser|from-browser)
+        COMPREPLY=($(compgen -W "firefox chrome chromium edge" -- "$cur"))
+        return ;;
+    -temp-dir|temp-dir)
+        COMPREPLY=($(compgen -d -- "$cur"))
+        return ;;
+    -cookie-file|cookie-file|-batch|batch|-out|out|-output|output|-tls-cert|tls-cert|-tls-key|tls-key)
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return ;;
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" $cmd -h 2>&1 | sed -n 's/^  \(-[a-z-]*\).*/\1/p')" -- "$cur"))
+    elif [[ ${COMP_CWORD} -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" -h 2>&1 | sed -n '/^Commands:/,/^$/s/^  \([a-z-]*\)$/\1/p')" -- "$cur"))
+    fi
+}
+complete -o default -F _nb_downloader nb-downloader
+`
+
+const zshCompletion = `# zsh completion for nb-downloader, using the bash completion
+autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+
+const fishCompletion = `# fish completion for nb-downloader
+function __nb_downloader_command
+    set -l words (commandline -opc)
+    if test (count $words) -gt 1; and not string match -q -- '-*' $words[2]
+        echo $words[2]
+    end
+end
+
+function __nb_downloader_flags
+    set -l words (commandline -opc)
+    $words[1] (__nb_downloader_command) -h 2>&1 | string match -r '^  -[a-z-]+' | string trim
+end
+
+function __nb_downloader_commands
+    set -l words (commandline -opc)
+    $words[1] -h 2>&1 | sed -n '/^Commands:/,/^$/s/^  \([a-z-]*\)$/\1/p'
+end
+
+function __nb_downloader_prev
+    set -l words (commandline -opc)
+    contains -- (string replace -r '^--' '-' -- $words[-1]) $argv
+end
+
+complete -c nb-downloader -f -n 'test (count (commandline -opc)) -eq 1; and not string match -q -- "-*" (commandline -ct)' -a '(__nb_downloader_commands)'
+complete -c nb-downloader -f -n 'string match -q -- "-*" (commandline -ct)' -a '(__nb_downloader_flags)'
+complete -c nb-downloader -f -n '__nb_downloader_prev -type' -a '{{types}}'
+complete -c nb-downloader -f -n '__nb_downloader_prev -format; and test (__nb_downloader_command) = urls' -a 'plain tsv'
+complete -c nb-downloader -f -n '__nb_downloader_prev -format; and test (__nb_downloader_command) = export' -a 'bibtex ris'
+complete -c nb-downloader -f -n '__nb_downloader_prev -format; and test (__nb_downloader_command) = wordfreq' -a 'csv json'
+complete -c nb-downloader -f -n '__nb_downloader_prev -format; and test (__nb_downloader_command) = marc' -a 'iso2709 xml'
+complete -c nb-downloader -f -n '__nb_downloader_prev -format; and not contains -- (__nb_downloader_command) urls export wordfreq marc' -a '{{formats}}'
+complete -c nb-downloader -f -n '__nb_downloader_prev -color-space' -a 'rgb gray cmyk'
+complete -c nb-downloader -f -n '__nb_downloader_prev -image-format' -a 'jpg png'
+complete -c nb-downloader -f -n '__nb_downloader_prev -on-conflict' -a 'overwrite skip rename error'
+complete -c nb-downloader -f -n '__nb_downloader_prev -from-browser' -a 'firefox chrome chromium edge'
+complete -c nb-downloader -F -n '__nb_downloader_prev -cookie-file -batch -out -output -tls-cert -tls-key -temp-dir'
+`
+
+// completionHelp explains how to install the completion scripts
+const completionHelp = `Usage: completion bash|zsh|fish
+
+Prints a shell completion script for nb-downloader. To install it:
+
+  bash  nb-downloader completion bash > /etc/bash_completion.d/nb-downloader
+        or add 'source <(nb-downloader completion bash)' to ~/.bashrc
+  zsh   add 'source <(nb-downloader completion zsh)' to ~/.zshrc
+  fish  nb-downloader completion fish > ~/.config/fish/completions/nb-downloader.fish
+
+Start a new shell afterwards. The scripts complete sub-commands, flags and
+the values of -type and -format.
+`
+
+// runCompletion prints the completion script for a shell
+func runCompletion(args []string) int {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), completionHelp)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	var script string
+	switch fs.Arg(0) {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell %q, expected 'bash', 'zsh' or 'fish'\n", fs.Arg(0))
+		return 1
+	}
+
+	fmt.Print(strings.NewReplacer(
+		"{{types}}", strings.Join(append(nbdownloader.DocumentTypes(), nbdownloader.AudioDocumentType), " "),
+		"{{formats}}", strings.Join(outputFormats, " "),
+	).Replace(script))
+	return 0
+}
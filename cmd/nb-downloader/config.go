@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configPath returns the location of the config file with default flag values
+func configPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.toml"), nil
+}
+
+// loadConfig reads the config file. Its keys are flag names without the
+// leading dash, e.g. cookie-file = "/home/me/cookies.txt". A missing config
+// file is empty.
+func loadConfig() (map[string]any, string, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := make(map[string]any)
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, path, nil
+		}
+		return nil, path, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+	return config, path, nil
+}
+
+// applyConfig sets the flags in fs from the config file before the command
+// line is parsed, so that flags given on the command line take precedence.
+// If only is given, just those flags are set and other keys are ignored,
+// since sub-commands use some flag names with a different meaning.
+// Otherwise keys that are not flags of fs are reported to catch typos.
+//
+// The values are set on the flags directly rather than with fs.Set, so
+// fs.Visit still only sees the flags given on the command line. The names
+// of the flags set from the config file are returned instead.
+func applyConfig(fs *flag.FlagSet, only ...string) (map[string]bool, error) {
+	config, path, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	configured := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(config)) {
+		if len(only) > 0 && !slices.Contains(only, key) {
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil {
+			if len(only) == 0 {
+				fmt.Fprintf(os.Stderr, "Warning: unknown setting %q in %s\n", key, path)
+			}
+			continue
+		}
+
+		if err := f.Value.Set(configValue(config[key])); err != nil {
+			return nil, fmt.Errorf("invalid value for %q in %s: %w", key, path, err)
+		}
+		configured[key] = true
+	}
+	return configured, nil
+}
+
+// configValue formats a TOML value as a flag value. Unquoted dates such as
+// issue-date = 2023-01-01 are decoded as times and formatted back as dates.
+func configValue(value any) string {
+	if t, ok := value.(time.Time); ok {
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+			return t.Format("2006-01-02")
+		}
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// writeDefaultConfig writes a config file with every flag of fs except skip
+// commented out at its default value, with its usage above it, as a
+// starting point for the user's own settings
+func writeDefaultConfig(w io.Writer, fs *flag.FlagSet, skip ...string) error {
+	fmt.Fprintln(w, "# nb-downloader settings. Remove the # in front of a setting to change its")
+	fmt.Fprintln(w, "# default; options given on the command line still take precedence.")
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(skip, f.Name) {
+			return
+		}
+		value := f.DefValue
+		// Strings are quoted in TOML, unlike booleans and numbers
+		if _, ok := f.Value.(flag.Getter).Get().(string); ok {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(w, "\n# %s\n# %s = %s\n", f.Usage, f.Name, value)
+	})
+	_, err := fmt.Fprintln(w)
+	return err
+}
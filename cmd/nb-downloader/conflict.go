@@ -0,0 +1,55 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// conflictPolicies lists the values of -on-conflict
+var conflictPolicies = []string{"overwrite", "skip", "rename", "error"}
+
+// targetPath returns the file or folder a download of id with opts writes
+func targetPath(id string, opts nbdownloader.DownloadOptions, audio bool) string {
+	if audio {
+		return nbdownloader.NewAudioBook(id, opts).TargetPath()
+	}
+	return nbdownloader.NewBook(id, opts).TargetPath()
+}
+
+// resolveConflict applies the -on-conflict policy before a download starts,
+// so that a conflict is not discovered only once all pages are downloaded.
+// It returns the options to download with, which for "rename" add _1, _2
+// and so on to the output name, and whether to download at all. A
+// skipped book is not an error; "error" returns one.
+func resolveConflict(id string, opts nbdownloader.DownloadOptions, policy string, audio bool) (nbdownloader.DownloadOptions, bool, error) {
+	path := targetPath(id, opts, audio)
+	if policy == "overwrite" || !exists(path) {
+		return opts, true, nil
+	}
+
+	switch policy {
+	case "skip":
+		fmt.Printf("%s already exists, skipping book %s\n", path, id)
+		return opts, false, nil
+	case "rename":
+		base := cmp.Or(opts.OutputName, id)
+		for n := 1; ; n++ {
+			opts.OutputName = base + "_" + strconv.Itoa(n)
+			if renamed := targetPath(id, opts, audio); !exists(renamed) {
+				fmt.Printf("%s already exists, saving to %s instead\n", path, renamed)
+				return opts, true, nil
+			}
+		}
+	}
+	return opts, false, fmt.Errorf("%s already exists, use -on-conflict to overwrite, skip or rename it", path)
+}
+
+// exists reports whether a file or folder exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
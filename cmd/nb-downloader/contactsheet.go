@@ -0,0 +1,222 @@
+//go:build !nopdf
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// Layout of a contact sheet page in mm
+const (
+	sheetWidth  = 210 // A4 portrait
+	sheetHeight = 297
+	sheetMargin = 10
+	sheetGap    = 3 // space between thumbnails
+	labelHeight = 4 // page label below each thumbnail
+)
+
+// Thumbnail width in pixels and grid columns of -contact-sheet previews
+const (
+	previewWidth = 200
+	previewCols  = 5
+)
+
+// runContactSheet downloads a thumbnail of every page and arranges them in a
+// grid on one or more PDF pages, to survey a book at a glance
+func runContactSheet(args []string) int {
+	fs := flag.NewFlagSet("contactsheet", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	length := fs.Int("length", 0, "Book length (will calculate if not provided)")
+	width := fs.Int("width", 200, "Thumbnail width in pixels to request")
+	cols := fs.Int("cols", 6, "Thumbnails per row")
+	rows := fs.Int("rows", 7, "Rows of thumbnails per PDF page")
+	stride := fs.Int("stride", 1, "Show only every Nth page")
+	out := fs.String("out", "", "Output file (default is <bookID>_contactsheet.pdf)")
+	fs.Parse(args)
+
+	if *cols < 1 || *rows < 1 || *width < 1 || *stride < 1 {
+		fmt.Fprintln(os.Stderr, "Invalid layout: -cols, -rows, -width and -stride must be positive")
+		return 1
+	}
+
+	b, err := common.newBook(fs, nbdownloader.DownloadOptions{Length: *length, ImageWidth: *width})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	if err := b.ResolveLength(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "nb-downloader-thumbnails-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating temporary folder:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	pages, err := fetchThumbnails(ctx, b, dir, *stride)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	pages = strided(pages, *stride)
+
+	cellW := float64(sheetWidth-2*sheetMargin) / float64(*cols)
+	cellH := float64(sheetHeight-2*sheetMargin) / float64(*rows)
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle("Contact sheet of "+b.ID(), true)
+	buildContactSheet(pages, *cols, *rows, cellW-sheetGap, cellH-sheetGap-labelHeight, pdf)
+
+	outPath := *out
+	if outPath == "" {
+		outPath = b.ID() + "_contactsheet.pdf"
+	}
+	if err := pdf.OutputFileAndClose(outPath); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing contact sheet:", err)
+		return 1
+	}
+	fmt.Printf("Saved %d thumbnails to %s\n", len(pages), outPath)
+	return 0
+}
+
+// fetchThumbnails downloads every stride-th page of the book to dir and
+// returns the paths of the thumbnails of all pages in reading order, where
+// the pages not downloaded are missing
+func fetchThumbnails(ctx context.Context, b *nbdownloader.Book, dir string, stride int) ([]string, error) {
+	pageIDs := b.PageIDs(ctx)
+	var pages []string
+	downloaded := 0
+	for i, pageID := range pageIDs {
+		path := filepath.Join(dir, pageID+"."+b.ImageFormat())
+		pages = append(pages, path)
+		if i%stride != 0 {
+			continue
+		}
+		fmt.Printf("\rDownloading thumbnails: %d/%d", i/stride+1, (len(pageIDs)+stride-1)/stride)
+		data, err := b.FetchPage(ctx, pageID)
+		if err != nil {
+			fmt.Printf("\nSkipping %v\n", err)
+			continue
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Println()
+			return nil, fmt.Errorf("error writing thumbnail: %w", err)
+		}
+		downloaded++
+	}
+	fmt.Println()
+	if downloaded == 0 {
+		return nil, errors.New("no thumbnails could be downloaded")
+	}
+	return pages, nil
+}
+
+// strided returns every stride-th of the pages, starting with the first,
+// that exists
+func strided(pages []string, stride int) []string {
+	var picked []string
+	for i := 0; i < len(pages); i += stride {
+		if _, err := os.Stat(pages[i]); err == nil {
+			picked = append(picked, pages[i])
+		}
+	}
+	return picked
+}
+
+// assembleContactSheet lays out every stride-th of the pages, skipping those
+// that were not downloaded, on A4 PDF pages in a grid of cols columns, each
+// thumbnail labelled with its page ID. Errors are left in the returned PDF.
+func assembleContactSheet(pages []string, stride int, cols int) *gofpdf.Fpdf {
+	// One row more than columns fills an A4 page with portrait thumbnails
+	rows := cols + 1
+	cellW := float64(sheetWidth-2*sheetMargin) / float64(cols)
+	cellH := float64(sheetHeight-2*sheetMargin) / float64(rows)
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	buildContactSheet(strided(pages, stride), cols, rows, cellW-sheetGap, cellH-sheetGap-labelHeight, pdf)
+	return pdf
+}
+
+// previewContactSheet downloads a thumbnail of every stride-th page of the
+// book and saves them as <bookID>_contactsheet.pdf, to look over the book
+// before downloading it in full. It reports whether the sheet was saved.
+func previewContactSheet(ctx context.Context, id string, opts nbdownloader.DownloadOptions, stride int) bool {
+	opts.ImageWidth = previewWidth
+	b := nbdownloader.NewBook(id, opts)
+	if err := b.ResolveLength(ctx); err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	dir, err := os.MkdirTemp("", "nb-downloader-thumbnails-")
+	if err != nil {
+		fmt.Println("Error creating temporary folder:", err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	pages, err := fetchThumbnails(ctx, b, dir, stride)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	pdf := assembleContactSheet(pages, stride, previewCols)
+	pdf.SetTitle("Contact sheet of "+id, true)
+
+	outPath := id + "_contactsheet.pdf"
+	if err := pdf.OutputFileAndClose(outPath); err != nil {
+		fmt.Println("Error writing contact sheet:", err)
+		return false
+	}
+	fmt.Printf("Contact sheet with one thumbnail per %d pages saved to %s\n", stride, outPath)
+	return true
+}
+
+// buildContactSheet adds the page images to pdf in a grid of cols × rows
+// thumbnails per PDF page, starting a new PDF page when one is full. Each
+// image is scaled to fit a thumbW × thumbH mm box, keeping its aspect ratio,
+// and labelled with its file name without extension, i.e. the page ID.
+// Errors are left in pdf.Err.
+func buildContactSheet(pages []string, cols, rows int, thumbW, thumbH float64, pdf *gofpdf.Fpdf) {
+	cellW := thumbW + sheetGap
+	cellH := thumbH + sheetGap + labelHeight
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.SetAutoPageBreak(false, 0)
+
+	for i, page := range pages {
+		slot := i % (cols * rows)
+		if slot == 0 {
+			pdf.AddPage()
+		}
+		x := sheetMargin + float64(slot%cols)*cellW
+		y := sheetMargin + float64(slot/cols)*cellH
+
+		opts := gofpdf.ImageOptions{ImageType: pdf.ImageTypeFromMime(mime.TypeByExtension(filepath.Ext(page)))}
+		info := pdf.RegisterImageOptions(page, opts)
+		if pdf.Err() {
+			return
+		}
+		imgW, imgH := info.Extent()
+		scale := min(thumbW/imgW, thumbH/imgH)
+		w, h := imgW*scale, imgH*scale
+		pdf.ImageOptions(page, x+(thumbW-w)/2, y+(thumbH-h)/2, w, h, false, opts, 0, "")
+
+		label := strings.TrimSuffix(filepath.Base(page), filepath.Ext(page))
+		pdf.SetXY(x, y+thumbH)
+		pdf.CellFormat(thumbW, labelHeight, label, "", 0, "C", false, 0, "")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build nopdf
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// runContactSheet reports that contact sheets need PDF support
+func runContactSheet(args []string) int {
+	fmt.Fprintln(os.Stderr, "contactsheet is not available in builds without PDF support (-tags nopdf)")
+	return 1
+}
+
+// previewContactSheet reports that contact sheets need PDF support
+func previewContactSheet(ctx context.Context, id string, opts nbdownloader.DownloadOptions, stride int) bool {
+	fmt.Println("-contact-sheet is not available in builds without PDF support (-tags nopdf)")
+	return false
+}
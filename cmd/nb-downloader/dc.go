@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// dcRecord is a simple Dublin Core record in the oai_dc container used by
+// OAI-PMH repositories
+type dcRecord struct {
+	XMLName     xml.Name `xml:"oai_dc:dc"`
+	OAIDC       string   `xml:"xmlns:oai_dc,attr"`
+	DC          string   `xml:"xmlns:dc,attr"`
+	Title       string   `xml:"dc:title"`
+	Creators    []string `xml:"dc:creator"`
+	Subjects    []string `xml:"dc:subject"`
+	Description string   `xml:"dc:description,omitempty"`
+	Publisher   string   `xml:"dc:publisher,omitempty"`
+	Date        string   `xml:"dc:date,omitempty"`
+	Type        string   `xml:"dc:type"`
+	Format      string   `xml:"dc:format,omitempty"`
+	Identifier  string   `xml:"dc:identifier"`
+	Language    string   `xml:"dc:language,omitempty"`
+	Rights      string   `xml:"dc:rights,omitempty"`
+}
+
+// manifestField returns the value of the first of the labels listed in the
+// manifest metadata, ignoring case
+func manifestField(meta *nbdownloader.Metadata, labels ...string) string {
+	for _, label := range labels {
+		for key, value := range meta.Fields {
+			if strings.EqualFold(key, label) {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// mediaTypes maps the extensions of downloaded books to dc:format values
+var mediaTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".epub": "application/epub+zip",
+}
+
+// downloadedFormat returns the media type of the book as last downloaded,
+// if it is in the index
+func downloadedFormat(id string) string {
+	entries, err := loadIndex()
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		if info, err := os.Stat(e.Path); err == nil && info.IsDir() {
+			// Image folders hold the pages as JPEG, or PNG with -image-format png
+			if pngs, _ := filepath.Glob(filepath.Join(e.Path, "*.png")); len(pngs) > 0 {
+				return "image/png"
+			}
+			return "image/jpeg"
+		}
+		return mediaTypes[strings.ToLower(filepath.Ext(e.Path))]
+	}
+	return ""
+}
+
+// marshalDublinCore converts the metadata to a Dublin Core XML record
+func marshalDublinCore(meta *nbdownloader.Metadata, format string) ([]byte, error) {
+	record := dcRecord{
+		OAIDC:       "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		DC:          "http://purl.org/dc/elements/1.1/",
+		Title:       meta.Title,
+		Creators:    meta.Authors,
+		Description: manifestField(meta, "description", "beskrivelse", "summary", "sammendrag"),
+		Publisher:   meta.Publisher,
+		Date:        meta.Year,
+		Type:        "Text", // DCMI type of books, newspapers and periodicals alike
+		Format:      format,
+		Identifier:  meta.URN,
+		Language:    meta.Language,
+		Rights:      meta.Rights,
+	}
+	// nb.no lists the subject headings as one field
+	for _, subject := range strings.Split(manifestField(meta, "subject", "emne", "emneord"), ";") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			record.Subjects = append(record.Subjects, subject)
+		}
+	}
+
+	out, err := xml.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// runDC saves the book's metadata as a Dublin Core XML file
+func runDC(args []string) int {
+	fs := flag.NewFlagSet("dc", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	fs.Parse(args)
+
+	b, err := common.newBook(fs, nbdownloader.DownloadOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	meta, err := b.FetchMetadata(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, err := marshalDublinCore(meta, downloadedFormat(meta.ID))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding Dublin Core:", err)
+		return 1
+	}
+	outPath := meta.ID + "_dc.xml"
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error saving Dublin Core:", err)
+		return 1
+	}
+	fmt.Println("Dublin Core metadata saved to", outPath)
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // register JPEG and PNG for image.Decode
+	_ "image/png"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Size of the grid the cover is reduced to for hashing. Comparing each cell
+// with its right neighbour gives hashWidth-1 bits per row, 64 in total.
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// coverHash computes a difference hash (dHash) of an image: the image is
+// reduced to a small grayscale grid and each bit records whether a cell is
+// brighter than its right neighbour. Similar images, e.g. the same cover
+// scanned at another width, give hashes that differ in only a few bits.
+func coverHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	var cells [hashHeight][hashWidth]float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * hashHeight / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			col := (x - bounds.Min.X) * hashWidth / bounds.Dx()
+			cells[row][col] += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+
+	// Cells differ in size by at most a pixel, so the sums can be compared
+	// directly without averaging
+	var hash uint64
+	for row := range cells {
+		for col := 0; col < hashWidth-1; col++ {
+			hash <<= 1
+			if cells[row][col] > cells[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// formatHash formats a cover hash as stored in the index
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// hashCoverImage returns the formatted hash of a JPEG or PNG cover image
+func hashCoverImage(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("error decoding cover: %w", err)
+	}
+	return formatHash(coverHash(img)), nil
+}
+
+// hashCoverFile returns the formatted hash of a JPEG or PNG cover file
+func hashCoverFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return hashCoverImage(data)
+}
+
+// hashCoverPDF returns the formatted hash of the first page of a PDF
+func hashCoverPDF(path string) (string, error) {
+	data, _, err := extractFirstPageImage(path)
+	if err != nil {
+		return "", err
+	}
+	return hashCoverImage(data)
+}
+
+// runDedup reports indexed books with similar covers, which are likely the
+// same book downloaded under different IDs
+func runDedup(args []string) int {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	threshold := fs.Int("threshold", 10, "Report covers whose hashes differ in fewer than this many of 64 bits")
+	fs.Parse(args)
+
+	if *threshold < 1 || *threshold > 64 {
+		fmt.Fprintln(os.Stderr, "Invalid -threshold value: must be between 1 and 64")
+		return 1
+	}
+
+	entries, err := loadIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Books indexed before cover hashes were recorded are hashed from their
+	// PDF if it still exists; the index itself is not changed
+	var hashed []IndexEntry
+	var hashes []uint64
+	unhashed := 0
+	for _, e := range entries {
+		if e.CoverHash == "" && strings.HasSuffix(strings.ToLower(e.Path), ".pdf") {
+			e.CoverHash, _ = hashCoverPDF(e.Path)
+		}
+		hash, err := strconv.ParseUint(e.CoverHash, 16, 64)
+		if err != nil {
+			unhashed++
+			continue
+		}
+		hashed = append(hashed, e)
+		hashes = append(hashes, hash)
+	}
+
+	found := 0
+	for i := range hashed {
+		for j := i + 1; j < len(hashed); j++ {
+			distance := bits.OnesCount64(hashes[i] ^ hashes[j])
+			if distance >= *threshold {
+				continue
+			}
+			found++
+			fmt.Printf("Possible duplicates (cover distance %d):\n", distance)
+			for _, e := range []IndexEntry{hashed[i], hashed[j]} {
+				fmt.Printf("  %s (%s) %s  %s\n", e.ID, e.Type, e.Title, e.Path)
+			}
+		}
+	}
+
+	if found == 0 {
+		fmt.Printf("No duplicates found among %d books\n", len(hashed))
+	}
+	if unhashed > 0 {
+		fmt.Printf("%d books have no cover to compare\n", unhashed)
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// parseDocTypes parses the comma-separated list of -types and checks that
+// each type is a book type that can be downloaded with issueDate
+func parseDocTypes(list, issueDate string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if t == nbdownloader.AudioDocumentType {
+			return nil, fmt.Errorf("-types cannot include %q, download audio recordings with -type %s", t, t)
+		}
+		if err := nbdownloader.ValidateDocumentType(t); err != nil {
+			return nil, err
+		}
+		if err := nbdownloader.ValidateIssueDate(t, issueDate); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("-types lists no document types")
+	}
+	return types, nil
+}
+
+// typeOptions returns opts for downloading the document as docType. The
+// issue date only applies to newspapers and periodicals, since it becomes
+// part of their page IDs.
+func typeOptions(opts nbdownloader.DownloadOptions, docType string) nbdownloader.DownloadOptions {
+	opts.DocumentType = docType
+	if docType != "avis" && docType != "tidsskrift" {
+		opts.IssueDate = ""
+	}
+	return opts
+}
+
+// downloadTypes downloads id as each of docTypes in turn, saving each to
+// <id>_<type>, and prints which types could be downloaded. It reports
+// whether at least one type succeeded, since trying several types is
+// usually done to find out which one has content.
+func downloadTypes(ctx context.Context, id string, docTypes []string, opts nbdownloader.DownloadOptions,
+	download func(string, nbdownloader.DownloadOptions) bool) bool {
+	var succeeded, failed []string
+	for _, docType := range docTypes {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("Trying book %s as %s\n", id, docType)
+		typeOpts := typeOptions(opts, docType)
+		typeOpts.OutputName = id + "_" + docType
+		if download(id, typeOpts) {
+			succeeded = append(succeeded, docType)
+		} else {
+			failed = append(failed, docType)
+		}
+	}
+
+	fmt.Printf("Book %s: ", id)
+	if len(succeeded) > 0 {
+		fmt.Printf("downloaded as %s", strings.Join(succeeded, ", "))
+	} else {
+		fmt.Print("no type could be downloaded")
+	}
+	if len(failed) > 0 {
+		fmt.Printf("; failed as %s", strings.Join(failed, ", "))
+	}
+	fmt.Println()
+	return len(succeeded) > 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// Exit codes of -dry-run
+const (
+	dryRunOK        = 0
+	dryRunError     = 1
+	dryRunAuthError = 2
+)
+
+// runDryRun checks that each book can be accessed without downloading it or
+// writing any files. It returns the exit code: dryRunAuthError if access to
+// any book was denied, otherwise dryRunError if any check failed.
+func runDryRun(ctx context.Context, ids []string, opts nbdownloader.DownloadOptions) int {
+	code := dryRunOK
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return dryRunError
+		}
+
+		if err := nbdownloader.ValidateBookID(id, opts.DocumentType); err != nil {
+			fmt.Printf("Book %s: %v\n", id, err)
+			if code == dryRunOK {
+				code = dryRunError
+			}
+			continue
+		}
+		b := nbdownloader.NewBook(id, opts)
+		fmt.Printf("Checking book %s (type: %s)\n", id, b.DocumentType())
+		err := b.CheckAccess(ctx)
+
+		var authErr *nbdownloader.AuthError
+		switch {
+		case err == nil:
+			fmt.Printf("Book %s: access granted\n", id)
+		case errors.As(err, &authErr):
+			fmt.Printf("Book %s: %v\n", id, err)
+			fmt.Println("Check your cookies with -cookie-file or -cookies.")
+			code = dryRunAuthError
+		default:
+			fmt.Printf("Book %s: %v\n", id, err)
+			if code == dryRunOK {
+				code = dryRunError
+			}
+		}
+	}
+	return code
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// urnResolverURL returns the permanent nb.no URL of an indexed book
+func (e IndexEntry) urnResolverURL() string {
+	return "https://urn.nb.no/URN:NBN:no-nb_" + e.Type + "_" + e.ID
+}
+
+// bibtexEscaper escapes characters with a special meaning in BibTeX values
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+)
+
+// marshalBibTeX converts index entries to BibTeX @book entries keyed by nb<ID>
+func marshalBibTeX(books []IndexEntry) string {
+	var sb strings.Builder
+	for i, book := range books {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "@book{nb%s,\n", book.ID)
+		if len(book.Authors) > 0 {
+			fmt.Fprintf(&sb, "  author = {%s},\n", bibtexEscaper.Replace(strings.Join(book.Authors, " and ")))
+		}
+		fmt.Fprintf(&sb, "  title = {%s},\n", bibtexEscaper.Replace(book.Title))
+		if book.Year != "" {
+			fmt.Fprintf(&sb, "  year = {%s},\n", bibtexEscaper.Replace(book.Year))
+		}
+		if book.Publisher != "" {
+			fmt.Fprintf(&sb, "  publisher = {%s},\n", bibtexEscaper.Replace(book.Publisher))
+		}
+		fmt.Fprintf(&sb, "  url = {%s}\n", book.urnResolverURL())
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+// marshalRIS converts index entries to RIS records, as read by Zotero,
+// Mendeley and EndNote
+func marshalRIS(books []IndexEntry) string {
+	var sb strings.Builder
+	tag := func(name, value string) {
+		// RIS values are single lines
+		value = strings.Join(strings.Fields(value), " ")
+		fmt.Fprintf(&sb, "%s  - %s\r\n", name, value)
+	}
+
+	for _, book := range books {
+		tag("TY", "BOOK")
+		for _, author := range book.Authors {
+			tag("AU", author)
+		}
+		tag("TI", book.Title)
+		if book.Year != "" {
+			tag("PY", book.Year)
+		}
+		if book.Publisher != "" {
+			tag("PB", book.Publisher)
+		}
+		tag("UR", book.urnResolverURL())
+		tag("ER", "")
+	}
+	return sb.String()
+}
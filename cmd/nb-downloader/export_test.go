@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with the file name in testdata, or writes it
+// there with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s:\n%q\nwant:\n%q", path, got, want)
+	}
+}
+
+func TestMarshalRIS(t *testing.T) {
+	books := []IndexEntry{
+		{
+			ID:        "2008011100001",
+			Type:      "digibok",
+			Title:     "Fjellvåken:\n  en roman fra Nordland",
+			Authors:   []string{"Hansen, Kari", "Berg, Ola"},
+			Year:      "1925",
+			Publisher: "Aschehoug",
+		},
+		{ID: "000042105", Type: "pliktmonografi", Title: "Årsmelding"},
+	}
+	checkGolden(t, "books.ris", marshalRIS(books))
+}
+
+func TestMarshalMARC21(t *testing.T) {
+	entered := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		golden string
+		meta   *nbdownloader.Metadata
+	}{
+		{"book.mrc", &nbdownloader.Metadata{
+			ID:        "2008011100001",
+			Type:      "digibok",
+			URN:       "URN:NBN:no-nb_digibok_2008011100001",
+			Title:     "Fjellvåken",
+			Authors:   []string{"Hansen, Kari", "Berg, Ola"},
+			Publisher: "Aschehoug",
+			Year:      "[1925]",
+			Language:  "nob",
+		}},
+		// No author, year or known language; a serial
+		{"issue.mrc", &nbdownloader.Metadata{
+			ID:    "aftenposten_null_null_19050322_46_138_1",
+			Type:  "avis",
+			URN:   "URN:NBN:no-nb_avis_aftenposten_null_null_19050322_46_138_1",
+			Title: "Aftenposten 1905.03.22",
+		}},
+	}
+	for _, tt := range tests {
+		checkGolden(t, tt.golden, marshalMARC21(tt.meta, entered))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// runExtractPage downloads a single page of a book as a JPEG file
+func runExtractPage(args []string) int {
+	fs := flag.NewFlagSet("extract-page", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	page := fs.String("page", "", "Page to download, e.g. 12, C1 (front cover) or I2 (introduction page)")
+	width := fs.Int("width", nbdownloader.DefaultImageWidth, "Image width to request")
+	out := fs.String("out", "", "Output file (default is <bookID>_<page>.jpg)")
+	fs.Parse(args)
+
+	if *page == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the page to download with -page")
+		return 1
+	}
+
+	b, err := common.newBook(fs, nbdownloader.DownloadOptions{ImageWidth: *width, Log: os.Stdout})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	outPath := *out
+	if outPath == "" {
+		outPath = b.ID() + "_" + *page + ".jpg"
+	}
+	if err := b.DownloadPage(context.Background(), *page, outPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("Page saved to", outPath)
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// historyLimit is the number of downloads -history lists
+const historyLimit = 20
+
+// historyPath returns the location of the download history,
+// ~/.local/share/nb-downloader/history.db unless XDG_DATA_HOME is set
+func historyPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error locating home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "nb-downloader", "history.db"), nil
+}
+
+// historyDB opens the download history on first use
+var historyDB = sync.OnceValues(func() (*sql.DB, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	return nbdownloader.OpenHistory(path)
+})
+
+// recordHistory adds a completed download of b to the download history
+func recordHistory(b *nbdownloader.Book) {
+	db, err := historyDB()
+	if err == nil {
+		err = b.RecordHistory(db)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error updating download history:", err)
+	}
+}
+
+// warnIfDownloaded tells the user if the book was downloaded before
+func warnIfDownloaded(w io.Writer, id, docType string) {
+	db, err := historyDB()
+	if err != nil {
+		return
+	}
+	if e, ok, _ := nbdownloader.LastDownload(db, id, docType); ok {
+		fmt.Fprintf(w, "Note: book %s was already downloaded on %s to %s\n",
+			id, e.Downloaded.Local().Format("2006-01-02 15:04"), e.Path)
+	}
+}
+
+// printHistory lists the most recent downloads, newest first. With a query
+// it lists all downloads whose book ID or path contains the query, ignoring
+// case.
+func printHistory(w io.Writer, query string) error {
+	db, err := historyDB()
+	if err != nil {
+		return err
+	}
+	limit := historyLimit
+	if query != "" {
+		limit = 0
+	}
+	entries, err := nbdownloader.SearchHistory(db, query, limit)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No downloads found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOWNLOADED\tID\tTYPE\tPAGES\tPATH")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", e.Downloaded.Local().Format("2006-01-02 15:04"), e.ID, e.Type, e.Pages, e.Path)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// IndexEntry records one successfully downloaded book in the global index
+type IndexEntry struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Title      string    `json:"title"`
+	Authors    []string  `json:"authors,omitempty"`
+	Year       string    `json:"year,omitempty"`
+	Publisher  string    `json:"publisher,omitempty"`
+	Language   string    `json:"language,omitempty"`
+	Pages      int       `json:"pages"`
+	Path       string    `json:"path"`
+	Downloaded time.Time `json:"downloaded"`
+	CoverHash  string    `json:"cover_hash,omitempty"`
+}
+
+// configDir returns the nb-downloader configuration directory,
+// e.g. ~/.config/nb-downloader on Linux
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error locating config directory: %w", err)
+	}
+	return filepath.Join(dir, "nb-downloader"), nil
+}
+
+// indexMu serializes the index updates of books downloaded at the same time
+var indexMu sync.Mutex
+
+// indexPath returns the location of the global book index
+func indexPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "index.json"), nil
+}
+
+// loadIndex reads the global book index. A missing index is empty.
+func loadIndex() ([]IndexEntry, error) {
+	path, err := indexPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+
+	var entries []IndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing index %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// saveIndex writes the global book index
+func saveIndex(entries []IndexEntry) error {
+	path, err := indexPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding index: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
+	return nil
+}
+
+// addToIndex adds an entry to the global index, replacing any previous
+// entry for the same book
+func addToIndex(entry IndexEntry) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	entries, err := loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for i, existing := range entries {
+		if existing.ID == entry.ID && existing.Type == entry.Type {
+			entries[i] = entry
+			return saveIndex(entries)
+		}
+	}
+	return saveIndex(append(entries, entry))
+}
+
+// findIndexEntry returns the index entry for a book whose output still
+// exists on disk
+func findIndexEntry(id, docType string) (IndexEntry, bool) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	entries, err := loadIndex()
+	if err != nil {
+		return IndexEntry{}, false
+	}
+	for _, e := range entries {
+		if e.ID == id && e.Type == docType {
+			if _, err := os.Stat(e.Path); err == nil {
+				return e, true
+			}
+		}
+	}
+	return IndexEntry{}, false
+}
+
+// recordInIndex adds the finished download to the global index
+func recordInIndex(ctx context.Context, b *nbdownloader.Book) {
+	outPath := b.OutputPath()
+	if abs, err := filepath.Abs(outPath); err == nil {
+		outPath = abs
+	}
+
+	var hash string
+	if cover := b.CoverPath(); cover != "" {
+		var err error
+		if hash, err = hashCoverFile(cover); err != nil {
+			fmt.Println("Error hashing cover:", err)
+		}
+	}
+
+	meta := b.Metadata(ctx)
+	err := addToIndex(IndexEntry{
+		ID:         b.ID(),
+		Type:       b.DocumentType(),
+		Title:      meta.Title,
+		Authors:    meta.Authors,
+		Year:       meta.Year,
+		Publisher:  meta.Publisher,
+		Language:   meta.Language,
+		Pages:      b.PageCount(),
+		Path:       outPath,
+		Downloaded: time.Now(),
+		CoverHash:  hash,
+	})
+	if err != nil {
+		fmt.Println("Error updating book index:", err)
+	}
+}
+
+// bookIDFromFilenameRe matches the book ID in file names like 2010101408082.pdf
+// or digibok_2010101408082_part01.pdf
+var bookIDFromFilenameRe = regexp.MustCompile(`(?:(digibok|pliktmonografi)_)?(\d{9,})`)
+
+// bookIDFromFilename guesses the book ID and document type from a file name
+func bookIDFromFilename(name string) (id, docType string, ok bool) {
+	m := bookIDFromFilenameRe.FindStringSubmatch(filepath.Base(name))
+	if m == nil {
+		return "", "", false
+	}
+	docType = m[1]
+	if docType == "" {
+		docType = "digibok"
+	}
+	return m[2], docType, true
+}
+
+// importPDFs adds the PDF files in dir to the global index and returns the
+// number of books added
+func importPDFs(dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pdf"))
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := loadIndex()
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool)
+	for _, e := range entries {
+		known[e.Type+"_"+e.ID] = true
+	}
+
+	added := 0
+	for _, path := range paths {
+		id, docType, ok := bookIDFromFilename(path)
+		if !ok {
+			fmt.Printf("Skipping %s: no book ID in file name\n", path)
+			continue
+		}
+		if known[docType+"_"+id] {
+			fmt.Printf("Skipping %s: book %s is already in the index\n", path, id)
+			continue
+		}
+
+		info, err := readPDFInfo(path)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", path, err)
+			continue
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", path, err)
+			continue
+		}
+
+		entry := IndexEntry{
+			ID:         id,
+			Type:       docType,
+			Title:      info.Title,
+			Pages:      info.Pages,
+			Path:       abs,
+			Downloaded: stat.ModTime(),
+		}
+		if entry.Title == "" {
+			entry.Title = strings.TrimSuffix(filepath.Base(path), ".pdf")
+		}
+		if info.Author != "" {
+			entry.Authors = []string{info.Author}
+		}
+		entry.CoverHash, _ = hashCoverPDF(path)
+
+		entries = append(entries, entry)
+		known[docType+"_"+id] = true
+		added++
+		fmt.Printf("Imported %s as book %s\n", path, id)
+	}
+
+	if added > 0 {
+		if err := saveIndex(entries); err != nil {
+			return 0, err
+		}
+	}
+	return added, nil
+}
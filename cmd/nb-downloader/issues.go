@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// issueDateLayout is the YYYY-MM-DD format of issue dates
+const issueDateLayout = "2006-01-02"
+
+// parseDateRange parses the -from-date and -to-date of a range of issues
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	if from == "" || to == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("-from-date and -to-date must be given together")
+	}
+	start, err := time.Parse(issueDateLayout, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -from-date %q, expected YYYY-MM-DD", from)
+	}
+	end, err := time.Parse(issueDateLayout, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -to-date %q, expected YYYY-MM-DD", to)
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-to-date %s is before -from-date %s", to, from)
+	}
+	return start, end, nil
+}
+
+// downloadIssues downloads every issue of the newspaper id published from
+// start to end, saving each to <id>_<date>. Days without an issue are
+// skipped silently, as most titles don't appear every day. It reports
+// whether every issue found was downloaded.
+func downloadIssues(ctx context.Context, id string, start, end time.Time, opts nbdownloader.DownloadOptions,
+	download func(string, nbdownloader.DownloadOptions) bool) bool {
+	var days, found, failed int
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if ctx.Err() != nil {
+			break
+		}
+		days++
+		date := day.Format(issueDateLayout)
+		issueOpts := opts
+		issueOpts.IssueDate = date
+		issueOpts.OutputName = id + "_" + date
+
+		ok, err := nbdownloader.NewBook(id, issueOpts).IssueAvailable(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Printf("Error checking issue %s: %v\n", date, err)
+			failed++
+			// Every other day would be refused as well
+			var authErr *nbdownloader.AuthError
+			if errors.As(err, &authErr) {
+				break
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		found++
+		fmt.Printf("Downloading issue %s of %s\n", date, id)
+		if !download(id, issueOpts) {
+			failed++
+		}
+	}
+
+	expected := int(end.Sub(start).Hours()/24) + 1
+	fmt.Printf("Issues of %s from %s to %s: found %d of %d days",
+		id, start.Format(issueDateLayout), end.Format(issueDateLayout), found, expected)
+	if days < expected {
+		fmt.Printf(" (stopped after %d days)", days)
+	}
+	if failed > 0 {
+		fmt.Printf(", %d failed", failed)
+	}
+	fmt.Println()
+	return failed == 0 && days == expected
+}
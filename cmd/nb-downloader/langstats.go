@@ -0,0 +1,118 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// languageNames names the ISO 639 codes nb.no uses for the languages most
+// common in its collection
+var languageNames = map[string]string{
+	"nob": "Norwegian Bokmål",
+	"nno": "Norwegian Nynorsk",
+	"nor": "Norwegian",
+	"sme": "Northern Sami",
+	"smj": "Lule Sami",
+	"sma": "Southern Sami",
+	"dan": "Danish",
+	"swe": "Swedish",
+	"fin": "Finnish",
+	"isl": "Icelandic",
+	"eng": "English",
+	"ger": "German",
+	"deu": "German",
+	"fre": "French",
+	"fra": "French",
+	"lat": "Latin",
+}
+
+// languageLabel returns the language as listed in langstats
+func languageLabel(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language == "" {
+		return "unknown"
+	}
+	if name, ok := languageNames[language]; ok {
+		return language + " (" + name + ")"
+	}
+	return language
+}
+
+// printLanguageStats writes the number of books per language to w, most
+// common first
+func printLanguageStats(w io.Writer, entries []IndexEntry) {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[languageLabel(e.Language)]++
+	}
+	languages := make([]string, 0, len(counts))
+	for language := range counts {
+		languages = append(languages, language)
+	}
+	slices.SortFunc(languages, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "LANGUAGE\tBOOKS\tSHARE\t")
+	for _, language := range languages {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", language, counts[language], 100*float64(counts[language])/float64(len(entries)))
+	}
+	tw.Flush()
+}
+
+// runLangStats prints how the books in the index are distributed over
+// languages
+func runLangStats(args []string) int {
+	fs := flag.NewFlagSet("langstats", flag.ExitOnError)
+	fetch := fs.Bool("fetch", false, "Look up the language of books indexed without one at nb.no and save it in the index")
+	fs.Parse(args)
+
+	entries, err := loadIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "The index is empty")
+		return 1
+	}
+
+	if *fetch {
+		updated := 0
+		for i, e := range entries {
+			if e.Language != "" {
+				continue
+			}
+			b := nbdownloader.NewBook(e.ID, nbdownloader.DownloadOptions{DocumentType: e.Type, Log: io.Discard})
+			meta, err := b.FetchMetadata(context.Background())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error fetching metadata of %s: %v\n", e.ID, err)
+				continue
+			}
+			if meta.Language != "" {
+				entries[i].Language = meta.Language
+				updated++
+			}
+		}
+		if updated > 0 {
+			if err := saveIndex(entries); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		fmt.Printf("Found the language of %d books\n\n", updated)
+	}
+
+	printLanguageStats(os.Stdout, entries)
+	return 0
+}
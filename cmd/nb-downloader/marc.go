@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// ISO 2709 delimiters
+const (
+	marcSubfieldDelimiter = "\x1f"
+	marcFieldTerminator   = "\x1e"
+	marcRecordTerminator  = "\x1d"
+)
+
+// marcField is a variable field of a MARC record: a control field (tag 00X)
+// with a value, or a data field with indicators and subfields
+type marcField struct {
+	tag        string
+	value      string
+	indicators string
+	subfields  [][2]string // code, value
+}
+
+// data returns the field as stored in ISO 2709, without the terminator
+func (f marcField) data() string {
+	if f.subfields == nil {
+		return f.value
+	}
+	var sb strings.Builder
+	sb.WriteString(f.indicators)
+	for _, sf := range f.subfields {
+		sb.WriteString(marcSubfieldDelimiter + sf[0] + sf[1])
+	}
+	return sb.String()
+}
+
+// marcYear matches a four-digit year in the free-form publication date
+var marcYear = regexp.MustCompile(`\b\d{4}\b`)
+
+// marcLanguage matches the three-letter language codes nb.no uses, which are
+// MARC language codes
+var marcLanguage = regexp.MustCompile(`^[a-z]{3}$`)
+
+// marcFields converts the metadata to the variable fields of a MARC 21
+// bibliographic record
+func marcFields(meta *nbdownloader.Metadata, entered time.Time) []marcField {
+	year := marcYear.FindString(meta.Year)
+	language := strings.ToLower(strings.TrimSpace(meta.Language))
+	if !marcLanguage.MatchString(language) {
+		language = "und"
+	}
+
+	// 008: date entered, a single known date or none, publication year,
+	// unknown place, book fields left blank, language, cataloging source
+	// other than a national bibliographic agency
+	dates := "s" + year + "    "
+	if year == "" {
+		dates = "nuuuuuuuu"
+	}
+	fixed := entered.Format("060102") + dates + "xx " +
+		strings.Repeat(" ", 17) + language + " " + "d"
+
+	fields := []marcField{
+		{tag: "001", value: meta.ID},
+		{tag: "008", value: fixed},
+		{tag: "024", indicators: "7 ", subfields: [][2]string{{"a", meta.URN}, {"2", "urn"}}},
+	}
+	if len(meta.Authors) > 0 {
+		fields = append(fields, marcField{tag: "100", indicators: "1 ", subfields: [][2]string{{"a", meta.Authors[0]}}})
+	}
+	// The first indicator says whether the title is the main entry
+	titleIndicators := "00"
+	if len(meta.Authors) > 0 {
+		titleIndicators = "10"
+	}
+	fields = append(fields, marcField{tag: "245", indicators: titleIndicators, subfields: [][2]string{{"a", meta.Title}}})
+	if meta.Publisher != "" || year != "" {
+		var pub [][2]string
+		if meta.Publisher != "" {
+			pub = append(pub, [2]string{"b", meta.Publisher})
+		}
+		if year != "" {
+			pub = append(pub, [2]string{"c", year})
+		}
+		fields = append(fields, marcField{tag: "264", indicators: " 1", subfields: pub})
+	}
+	for _, author := range meta.Authors[min(1, len(meta.Authors)):] {
+		fields = append(fields, marcField{tag: "700", indicators: "1 ", subfields: [][2]string{{"a", author}}})
+	}
+	fields = append(fields, marcField{tag: "856", indicators: "40", subfields: [][2]string{
+		{"u", "https://urn.nb.no/" + meta.URN},
+		{"z", "Digitized edition at nb.no"},
+	}})
+	return fields
+}
+
+// marcLeader returns the record leader: a new, UTF-8 encoded, minimal level
+// record of language material, a monograph or for newspapers and
+// periodicals a serial
+func marcLeader(meta *nbdownloader.Metadata, recordLength, baseAddress int) string {
+	level := "m"
+	if meta.Type == "avis" || meta.Type == "tidsskrift" {
+		level = "s"
+	}
+	return fmt.Sprintf("%05dna%s a22%05d7u 4500", recordLength, level, baseAddress)
+}
+
+// marshalMARC21 encodes the metadata as a MARC 21 bibliographic record in
+// ISO 2709 (binary MARC), as imported by library systems such as Koha and
+// Alma, entered on the date of entered
+func marshalMARC21(meta *nbdownloader.Metadata, entered time.Time) string {
+	return encodeISO2709(meta, marcFields(meta, entered))
+}
+
+// encodeISO2709 returns the leader, directory and fields of a record
+func encodeISO2709(meta *nbdownloader.Metadata, fields []marcField) string {
+	// The directory lists the tag, length and start of every field, in
+	// bytes
+	var directory, data strings.Builder
+	for _, f := range fields {
+		field := f.data() + marcFieldTerminator
+		fmt.Fprintf(&directory, "%s%04d%05d", f.tag, len(field), data.Len())
+		data.WriteString(field)
+	}
+	directory.WriteString(marcFieldTerminator)
+
+	baseAddress := 24 + directory.Len()
+	recordLength := baseAddress + data.Len() + len(marcRecordTerminator)
+	return marcLeader(meta, recordLength, baseAddress) + directory.String() + data.String() + marcRecordTerminator
+}
+
+// MARCXML elements, see https://www.loc.gov/standards/marcxml/
+type (
+	marcXMLRecord struct {
+		XMLName       xml.Name           `xml:"http://www.loc.gov/MARC21/slim record"`
+		Leader        string             `xml:"leader"`
+		ControlFields []marcXMLControl   `xml:"controlfield"`
+		DataFields    []marcXMLDataField `xml:"datafield"`
+	}
+	marcXMLControl struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	}
+	marcXMLDataField struct {
+		Tag       string            `xml:"tag,attr"`
+		Ind1      string            `xml:"ind1,attr"`
+		Ind2      string            `xml:"ind2,attr"`
+		Subfields []marcXMLSubfield `xml:"subfield"`
+	}
+	marcXMLSubfield struct {
+		Code  string `xml:"code,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+// marshalMARCXML encodes the metadata as a MARC 21 record in MARCXML,
+// entered on the date of entered
+func marshalMARCXML(meta *nbdownloader.Metadata, entered time.Time) (string, error) {
+	// The leader is that of the binary record, so the lengths match
+	fields := marcFields(meta, entered)
+	record := marcXMLRecord{Leader: encodeISO2709(meta, fields)[:24]}
+	for _, f := range fields {
+		if f.subfields == nil {
+			record.ControlFields = append(record.ControlFields, marcXMLControl{Tag: f.tag, Value: f.value})
+			continue
+		}
+		df := marcXMLDataField{Tag: f.tag, Ind1: f.indicators[:1], Ind2: f.indicators[1:]}
+		for _, sf := range f.subfields {
+			df.Subfields = append(df.Subfields, marcXMLSubfield{Code: sf[0], Value: sf[1]})
+		}
+		record.DataFields = append(record.DataFields, df)
+	}
+
+	out, err := xml.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(out) + "\n", nil
+}
+
+// runMARC prints a MARC 21 record of the book for import into a library
+// system
+func runMARC(args []string) int {
+	fs := flag.NewFlagSet("marc", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", "iso2709", "Output format: 'iso2709' (binary MARC) or 'xml' (MARCXML)")
+	fs.Parse(args)
+
+	if *format != "iso2709" && *format != "xml" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q, expected 'iso2709' or 'xml'\n", *format)
+		return 1
+	}
+
+	b, err := common.newBook(fs, nbdownloader.DownloadOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	meta, err := b.FetchMetadata(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *format == "xml" {
+		out, err := marshalMARCXML(meta, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error encoding MARCXML:", err)
+			return 1
+		}
+		fmt.Print(out)
+		return 0
+	}
+	fmt.Print(marshalMARC21(meta, time.Now()))
+	return 0
+}
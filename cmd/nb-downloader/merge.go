@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// parseMergeIDs parses the comma-separated book IDs of -merge and checks
+// that each is an ID of docType
+func parseMergeIDs(list, docType string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			if err := nbdownloader.ValidateBookID(id, docType); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		return nil, fmt.Errorf("-merge needs at least two book IDs, e.g. -merge ID1,ID2")
+	}
+	return ids, nil
+}
+
+// mergeBooks downloads each book into its own temporary folder and combines
+// them in order into one PDF at outPath. It reports whether the merged PDF
+// was saved; if any book fails, nothing is merged.
+func mergeBooks(ctx context.Context, ids []string, opts nbdownloader.DownloadOptions, outPath string) bool {
+	// The books are only assembled as a whole, so they get no output or
+	// sidecar of their own
+	opts.Format = "none"
+	opts.NoSidecar = true
+
+	var books []*nbdownloader.Book
+	for i, id := range ids {
+		fmt.Printf("[%d/%d] Book %s\n", i+1, len(ids), id)
+		b := nbdownloader.NewBook(id, opts)
+		if err := b.Download(ctx); err != nil {
+			fmt.Println(err)
+			fmt.Println("Not merging, book", id, "could not be downloaded")
+			return false
+		}
+		books = append(books, b)
+	}
+
+	fmt.Println("Merging books into", outPath)
+	level := opts.CompressLevel
+	if opts.NoCompress {
+		level = 0
+	}
+	if err := nbdownloader.MergePDF(ctx, outPath, books, level); err != nil {
+		fmt.Println("Error saving merged PDF:", err)
+		return false
+	}
+	fmt.Printf("Merged PDF of %d books saved to %s\n", len(books), outPath)
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// pdfcpu would otherwise install its config file and fonts in the user's
+// config directory the first time it reads a PDF
+func init() {
+	model.ConfigPath = "disable"
+}
+
+// pdfcpuConfig returns the pdfcpu configuration for reading PDFs. Relaxed
+// validation accepts the small deviations from the standard common in PDFs
+// from other tools.
+func pdfcpuConfig() *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// pdfInfo holds the document information fields read from a PDF file
+type pdfInfo struct {
+	Title  string
+	Author string
+	Pages  int
+}
+
+// readPDFInfo reads the title, author and page count of a PDF file with
+// pdfcpu
+func readPDFInfo(path string) (*pdfInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := api.PDFInfo(f, path, nil, pdfcpuConfig())
+	if err != nil {
+		return nil, fmt.Errorf("error reading PDF: %w", err)
+	}
+	return &pdfInfo{Title: info.Title, Author: info.Author, Pages: info.PageCount}, nil
+}
+
+// extractFirstPageImage returns the largest image drawn on the first page
+// of a PDF file and its file extension, "jpg", "png" or "tif". The page is
+// found through the page tree, so the objects may be in any order or in
+// object streams. JPEG images are returned as they are and Flate compressed
+// ones, such as the PNG pages of this tool, as PNG. In scanned books the
+// page is the image.
+func extractFirstPageImage(path string) (data []byte, ext string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	pages, err := api.ExtractImagesRaw(f, []string{"1"}, pdfcpuConfig())
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading PDF: %w", err)
+	}
+	var largest *model.Image
+	for _, page := range pages {
+		for _, img := range page {
+			if img.IsImgMask || img.Thumb {
+				continue
+			}
+			if largest == nil || img.Width*img.Height > largest.Width*largest.Height {
+				largest = &img
+			}
+		}
+	}
+	if largest == nil {
+		return nil, "", errors.New("no image found on the first page of the PDF")
+	}
+	data, err = io.ReadAll(largest)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading image: %w", err)
+	}
+	return data, largest.FileType, nil
+}
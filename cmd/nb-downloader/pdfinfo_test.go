@@ -0,0 +1,160 @@
+//go:build !nopdf
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// downloadTestPDF downloads a two-page book with the title and author from
+// a local server and returns the path of the PDF. The pages are served as
+// PNG if imageFormat is "png" and as JPEG otherwise.
+func downloadTestPDF(t *testing.T, title, author, imageFormat string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifest"):
+			fmt.Fprintf(w, `{"label": %q, "metadata": [{"label": "Creator", "value": %q}],
+				"sequences": [{"canvases": [
+					{"@id": "URN:NBN:no-nb_digibok_2008011100001_0001"},
+					{"@id": "URN:NBN:no-nb_digibok_2008011100001_0002"}
+				]}]}`, title, author)
+		case strings.HasSuffix(r.URL.Path, "/default.png"):
+			png.Encode(w, testPage(color.Gray{0x40}))
+		case strings.HasSuffix(r.URL.Path, "/default.jpg"):
+			jpeg.Encode(w, testPage(color.Gray{0x40}), nil)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	b := nbdownloader.NewBook("2008011100001", nbdownloader.DownloadOptions{
+		BaseURL:     server.URL,
+		APIBaseURL:  server.URL,
+		TempDir:     dir,
+		OutputName:  filepath.Join(dir, "2008011100001"),
+		ImageFormat: imageFormat,
+		NoSidecar:   true,
+		AssumeYes:   true,
+	})
+	if err := b.Download(context.Background()); err != nil {
+		t.Fatalf("Download() = %v", err)
+	}
+	return b.OutputPath()
+}
+
+// testPage returns a small page image filled with c
+func testPage(c color.Gray) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 60, 90))
+	for i := range img.Pix {
+		img.Pix[i] = c.Y
+	}
+	return img
+}
+
+func TestReadPDFInfo(t *testing.T) {
+	path := downloadTestPDF(t, "Fjellvåken", "Hansen, Kari", "jpg")
+	info, err := readPDFInfo(path)
+	if err != nil {
+		t.Fatalf("readPDFInfo() = %v", err)
+	}
+	if info.Title != "Fjellvåken" || info.Author != "Hansen, Kari" || info.Pages != 2 {
+		t.Errorf("readPDFInfo() = %+v, want Fjellvåken by Hansen, Kari with 2 pages", info)
+	}
+
+	if _, err := readPDFInfo(filepath.Join(t.TempDir(), "missing.pdf")); err == nil {
+		t.Error("readPDFInfo() of a missing file succeeded")
+	}
+	if _, err := readPDFInfo(writeFile(t, "broken.pdf", []byte("%PDF-1.4\nnot a PDF"))); err == nil {
+		t.Error("readPDFInfo() of a broken file succeeded")
+	}
+}
+
+func TestExtractFirstPageImage(t *testing.T) {
+	for _, format := range []string{"jpg", "png"} {
+		path := downloadTestPDF(t, "Fjellvåken", "Hansen, Kari", format)
+
+		// pdfcpu rewrites the PDF with its objects in object streams, which
+		// can only be found through the cross-reference stream
+		packed := filepath.Join(t.TempDir(), "packed.pdf")
+		if err := api.OptimizeFile(path, packed, pdfcpuConfig()); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(packed); err != nil || !bytes.Contains(data, []byte("/ObjStm")) {
+			t.Fatalf("rewritten PDF has no object streams: %v", err)
+		}
+
+		for _, path := range []string{path, packed} {
+			data, ext, err := extractFirstPageImage(path)
+			if err != nil {
+				t.Fatalf("extractFirstPageImage() of a PDF with %s pages = %v", format, err)
+			}
+			if ext != format {
+				t.Errorf("extractFirstPageImage() of a PDF with %s pages returned a %s image", format, ext)
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("extracted %s image doesn't decode: %v", ext, err)
+			}
+			if size := img.Bounds().Size(); size != image.Pt(60, 90) {
+				t.Errorf("extracted image is %v, want 60x90", size)
+			}
+		}
+	}
+
+	if _, _, err := extractFirstPageImage(writeFile(t, "broken.pdf", []byte("%PDF-1.4\nnot a PDF"))); err == nil {
+		t.Error("extractFirstPageImage() of a broken file succeeded")
+	}
+}
+
+func TestBookIDFromFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		docType string
+		ok      bool
+	}{
+		{"2010101408082.pdf", "2010101408082", "digibok", true},
+		{"/books/digibok_2010101408082_part01.pdf", "2010101408082", "digibok", true},
+		{"pliktmonografi_000042105.pdf", "000042105", "pliktmonografi", true},
+		{"Fjellvåken (2010101408082).pdf", "2010101408082", "digibok", true},
+		// Shorter numbers are years or part numbers, not IDs
+		{"notes_2010.pdf", "", "", false},
+		{"book.pdf", "", "", false},
+	}
+	for _, tt := range tests {
+		id, docType, ok := bookIDFromFilename(tt.name)
+		if id != tt.id || docType != tt.docType || ok != tt.ok {
+			t.Errorf("bookIDFromFilename(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.name, id, docType, ok, tt.id, tt.docType, tt.ok)
+		}
+	}
+}
+
+// writeFile writes data to a file named name in a temporary folder and
+// returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Requests per minute each client IP may send to the web UI API
+const (
+	startRateLimit = 10 // POST /api/downloads
+	pollRateLimit  = 60 // progress, file and queue requests
+)
+
+// ipRateLimiter limits the requests of each client IP with a token bucket
+// that refills at perMinute tokens a minute and holds at most perMinute
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	limit    rate.Limit
+	burst    int
+	swept    time.Time
+}
+
+func newIPRateLimiter(perMinute int) *ipRateLimiter {
+	return &ipRateLimiter{
+		limiters: make(map[string]*rate.Limiter),
+		limit:    rate.Every(time.Minute / time.Duration(perMinute)),
+		burst:    perMinute,
+		swept:    time.Now(),
+	}
+}
+
+// limiter returns the token bucket of ip
+func (l *ipRateLimiter) limiter(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A full bucket is no different from a new one, so those are dropped
+	// now and then to keep the map from growing with every client seen
+	if time.Since(l.swept) > time.Minute {
+		for key, lim := range l.limiters {
+			if lim.Tokens() >= float64(l.burst) {
+				delete(l.limiters, key)
+			}
+		}
+		l.swept = time.Now()
+	}
+
+	lim, ok := l.limiters[ip]
+	if !ok {
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[ip] = lim
+	}
+	return lim
+}
+
+// middleware refuses requests from a client IP that is over the limit
+// with 429 Too Many Requests, and a Retry-After header with the seconds
+// until its next request is allowed
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// X-Forwarded-For is not trusted, anyone could set it to get a
+		// fresh bucket
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		res := l.limiter(ip).Reserve()
+		if delay := res.Delay(); delay > 0 {
+			res.Cancel()
+			seconds := strconv.Itoa(int(math.Ceil(delay.Seconds())))
+			w.Header().Set("Retry-After", seconds)
+			http.Error(w, "too many requests, try again in "+seconds+" seconds", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
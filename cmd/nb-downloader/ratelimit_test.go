@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPRateLimiterMiddleware(t *testing.T) {
+	handler := newIPRateLimiter(2).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// The bucket holds a minute's worth of requests
+	for i := range 2 {
+		if w := request("192.0.2.1:1234"); w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, http.StatusNoContent)
+		}
+	}
+
+	// Two requests a minute refill a token every 30 seconds. Refused
+	// requests don't take a token, so the wait doesn't grow.
+	for range 2 {
+		w := request("192.0.2.1:5678")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("request over the limit: status %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if got := w.Header().Get("Retry-After"); got != "30" {
+			t.Errorf("Retry-After = %q, want \"30\"", got)
+		}
+	}
+
+	// Other clients have their own bucket
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusNoContent {
+		t.Errorf("request from another IP: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := request("[2001:db8::1]:1234"); w.Code != http.StatusNoContent {
+		t.Errorf("request from an IPv6 address: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// searchContext is the number of characters shown on either side of a match
+const searchContext = 50
+
+// runSearchText searches the page texts saved by -ocr-text and prints the
+// matches as JSON
+func runSearchText(args []string) int {
+	fs := flag.NewFlagSet("search-text", flag.ExitOnError)
+	bookID := fs.String("id", "", "Book ID, or output name if the book was saved under another one")
+	query := fs.String("query", "", "Text to search for, regardless of case")
+	fs.Parse(args)
+
+	if *bookID == "" || *query == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the book with -id and the text to search for with -query")
+		return 1
+	}
+
+	dir := nbdownloader.PageTextDir(*bookID)
+	matches, err := nbdownloader.SearchPageText(dir, *query, searchContext)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "No page text found in %s, download the book with -ocr-text first\n", dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(matches, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// Built-in Norwegian sentiment lexicon of common words, bokmål and nynorsk.
+// A larger lexicon such as NorSentLex can be given with -positive and
+// -negative.
+var (
+	positiveWords = makeSet(strings.Fields(`
+		beundre beundring blid deilig elske elsket elskede elsker fantastisk
+		fin fine flott flotte fornøyd fred fredelig fri frihet fryd fryde
+		glad glade glede gleden gleder gledet god gode godhet godt gratulere
+		herlig herlige hjertelig hjelp hjelpe hyggelig håp håpe håper jubel
+		kjærleik kjærlighet kjær kjære klok lykke lykkeleg lykkelig lykkelige
+		lys lyse mild modig morsom munter nydelig perfekt pen pene rik rolig
+		seier sikker smil smile smilte snill snille sol solskinn spennende
+		stolt sterk sunn søt takk takknemlig trygg trygge trygt underbar
+		vakker vakre varm varme vel velsignet vennlig venn venner vidunderlig
+		ære ærlig ønske
+	`))
+	negativeWords = makeSet(strings.Fields(`
+		angst bitter blod bråk dårleg dårlig død døde døden dø dør ensom
+		farlig fare feig fiende fiender forferdelig fortvilelse fortvilet
+		frykt frykte fryktelig grusom grusomme gråt gråte hat hate hater
+		hevn kald kamp krig lei lidelse lide mørk mørke mørket nød ond onde
+		ondskap redd redsel sint sinne skam skade skadet skrik skrek skyld
+		slem smerte smertefull sorg sorgen stygg sulten sult svak syk sykdom
+		synd tap tragisk trist triste uhell ulykke ulykkelig ulykkelige
+		urett vanskelig vondt vond vrede ødelagt ødelegge
+	`))
+)
+
+// sentimentPoint is the sentiment of one chapter or section of a book
+type sentimentPoint struct {
+	Index     int     `json:"index"`
+	Title     string  `json:"title,omitempty"`
+	FirstPage string  `json:"first_page"`
+	LastPage  string  `json:"last_page"`
+	Words     int     `json:"words"`
+	Positive  int     `json:"positive"`
+	Negative  int     `json:"negative"`
+	Score     float64 `json:"score"` // (positive - negative) / words
+}
+
+// loadWordList reads a lexicon file with one word per line. Blank lines and
+// lines starting with # are skipped.
+func loadWordList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading lexicon: %w", err)
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			set[line] = true
+		}
+	}
+	return set, scanner.Err()
+}
+
+// words splits text into lower-case words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// scoreSentiment counts the positive and negative words of the pages
+func scoreSentiment(pages []nbdownloader.PageText, positive, negative map[string]bool) sentimentPoint {
+	p := sentimentPoint{FirstPage: pages[0].Page, LastPage: pages[len(pages)-1].Page}
+	for _, page := range pages {
+		for _, w := range words(page.Text) {
+			p.Words++
+			if positive[w] {
+				p.Positive++
+			}
+			if negative[w] {
+				p.Negative++
+			}
+		}
+	}
+	if p.Words > 0 {
+		p.Score = float64(p.Positive-p.Negative) / float64(p.Words)
+	}
+	return p
+}
+
+// plotSentiment draws the sentiment arc as horizontal bars, negative to the
+// left of the axis and positive to the right
+func plotSentiment(points []sentimentPoint) {
+	const half = 20 // bar width on either side
+	maxScore := 0.0
+	for _, p := range points {
+		maxScore = max(maxScore, math.Abs(p.Score))
+	}
+	for _, p := range points {
+		n := 0
+		if maxScore > 0 {
+			n = int(math.Round(math.Abs(p.Score) / maxScore * half))
+		}
+		left, right := strings.Repeat(" ", half), strings.Repeat(" ", half)
+		if p.Score < 0 {
+			left = strings.Repeat(" ", half-n) + strings.Repeat("█", n)
+		} else {
+			right = strings.Repeat("█", n) + strings.Repeat(" ", half-n)
+		}
+		label := p.Title
+		if label == "" {
+			label = "p. " + p.FirstPage + "-" + p.LastPage
+		}
+		if utf8.RuneCountInString(label) > 30 {
+			label = string([]rune(label)[:29]) + "…"
+		}
+		fmt.Printf("%3d %-30s %s|%s %+.4f\n", p.Index, label, left, right, p.Score)
+	}
+}
+
+// runSentiment scores the sentiment of each chapter of a book from the
+// page text saved by -ocr-text
+func runSentiment(args []string) int {
+	fs := flag.NewFlagSet("sentiment", flag.ExitOnError)
+	bookID := fs.String("id", "", "Book ID, or output name if the book was saved under another one")
+	positiveFile := fs.String("positive", "", "File with positive words, one per line, instead of the built-in lexicon")
+	negativeFile := fs.String("negative", "", "File with negative words, one per line, instead of the built-in lexicon")
+	sectionPages := fs.Int("section-pages", 10, "Pages per section if no chapters are found")
+	plot := fs.Bool("plot", false, "Draw the sentiment arc in the terminal instead of printing JSON")
+	fs.Parse(args)
+
+	if *bookID == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the book with -id")
+		return 1
+	}
+	if *sectionPages < 1 {
+		fmt.Fprintln(os.Stderr, "-section-pages must be at least 1")
+		return 1
+	}
+
+	positive, negative := positiveWords, negativeWords
+	var err error
+	if *positiveFile != "" {
+		if positive, err = loadWordList(*positiveFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *negativeFile != "" {
+		if negative, err = loadWordList(*negativeFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	dir := nbdownloader.PageTextDir(*bookID)
+	chapters, err := nbdownloader.TextChapters(dir)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "No page text found in %s, download the book with -ocr-text first\n", dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Without running headers the book is cut into sections of equal length
+	unit := "chapter"
+	if chapters == nil {
+		unit = "section"
+		pages, err := nbdownloader.ReadPageTexts(dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for start := 0; start < len(pages); start += *sectionPages {
+			chapters = append(chapters, nbdownloader.Chapter{Pages: pages[start:min(start+*sectionPages, len(pages))]})
+		}
+	}
+
+	points := []sentimentPoint{}
+	for _, c := range chapters {
+		p := scoreSentiment(c.Pages, positive, negative)
+		p.Index = len(points) + 1
+		p.Title = c.Title
+		points = append(points, p)
+	}
+
+	if *plot {
+		plotSentiment(points)
+		return 0
+	}
+	out, err := json.MarshalIndent(struct {
+		BookID string           `json:"book_id"`
+		Unit   string           `json:"unit"` // "chapter" or "section"
+		Points []sentimentPoint `json:"points"`
+	}{*bookID, unit, points}, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
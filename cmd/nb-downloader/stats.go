@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// pageBuckets are the page count ranges of the stats histogram
+var pageBuckets = []struct {
+	label    string
+	min, max int
+}{
+	{"0-50", 0, 50},
+	{"51-100", 51, 100},
+	{"101-200", 101, 200},
+	{"201-500", 201, 500},
+	{"501+", 501, int(^uint(0) >> 1)},
+}
+
+var yearRe = regexp.MustCompile(`\b(1[0-9]{3}|20[0-9]{2})\b`)
+
+// diskUsage returns the size of a file, or the total size of a directory
+func diskUsage(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// printStats writes collection statistics for the index entries to w
+func printStats(w io.Writer, entries []IndexEntry) {
+	totalPages := 0
+	histogram := make([]int, len(pageBuckets))
+	types := make(map[string]int)
+	minYear, maxYear := 0, 0
+	var totalSize int64
+
+	for _, e := range entries {
+		totalPages += e.Pages
+		for i, bucket := range pageBuckets {
+			if e.Pages >= bucket.min && e.Pages <= bucket.max {
+				histogram[i]++
+				break
+			}
+		}
+		types[e.Type]++
+
+		if m := yearRe.FindString(e.Year); m != "" {
+			year, _ := strconv.Atoi(m)
+			if minYear == 0 || year < minYear {
+				minYear = year
+			}
+			if year > maxYear {
+				maxYear = year
+			}
+		}
+
+		totalSize += diskUsage(e.Path)
+	}
+
+	fmt.Fprintf(w, "Total books: %d\n", len(entries))
+	fmt.Fprintf(w, "Total pages: %d\n", totalPages)
+
+	fmt.Fprintln(w, "Page count histogram:")
+	maxCount := 0
+	for _, count := range histogram {
+		maxCount = max(maxCount, count)
+	}
+	for i, bucket := range pageBuckets {
+		bar := ""
+		if maxCount > 0 {
+			bar = strings.Repeat("#", histogram[i]*40/maxCount)
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %-8s %5d %s", bucket.label, histogram[i], bar), " "))
+	}
+
+	commonType, commonCount := "", 0
+	for t, count := range types {
+		if count > commonCount || (count == commonCount && t < commonType) {
+			commonType, commonCount = t, count
+		}
+	}
+	if commonType != "" {
+		fmt.Fprintf(w, "Most common document type: %s (%d books)\n", commonType, commonCount)
+	}
+
+	if minYear != 0 {
+		fmt.Fprintf(w, "Year range: %d-%d\n", minYear, maxYear)
+	}
+
+	fmt.Fprintf(w, "Total size on disk: %s\n", nbdownloader.FormatBytes(totalSize))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/no"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// textIndexMapping describes the pages in the full-text index. Every page
+// is a document, with its text analyzed as Norwegian so that searches find
+// inflected forms of a word too. The book ID and page are matched exactly.
+func textIndexMapping() mapping.IndexMapping {
+	keyword := bleve.NewKeywordFieldMapping()
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = no.AnalyzerName
+
+	page := bleve.NewDocumentStaticMapping()
+	page.AddFieldMappingsAt("id", keyword)
+	page.AddFieldMappingsAt("title", bleve.NewTextFieldMapping())
+	page.AddFieldMappingsAt("page", keyword)
+	page.AddFieldMappingsAt("text", text)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = page
+	return m
+}
+
+// indexedPage is a page in the full-text index
+type indexedPage struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Page  string `json:"page"`
+	Text  string `json:"text"`
+}
+
+// textIndexPath returns the location of the full-text index
+func textIndexPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "search.bleve"), nil
+}
+
+// openTextIndex opens the Bleve full-text index, creating it if needed
+func openTextIndex() (bleve.Index, error) {
+	path, err := textIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("error creating config directory: %w", err)
+		}
+		index, err = bleve.New(path, textIndexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening text index %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// setTextIndexBook replaces the pages of a book in the index. Pages are
+// stored as <id>/<page>.
+func setTextIndexBook(index bleve.Index, id, title string, pages []nbdownloader.PageText) error {
+	query := bleve.NewTermQuery(id)
+	query.SetField("id")
+	// The first search only counts the pages indexed before
+	count, err := index.Search(bleve.NewSearchRequestOptions(query, 0, 0, false))
+	if err != nil {
+		return fmt.Errorf("error reading text index: %w", err)
+	}
+	indexed, err := index.Search(bleve.NewSearchRequestOptions(query, int(count.Total), 0, false))
+	if err != nil {
+		return fmt.Errorf("error reading text index: %w", err)
+	}
+
+	batch := index.NewBatch()
+	for _, hit := range indexed.Hits {
+		batch.Delete(hit.ID)
+	}
+	for _, page := range pages {
+		doc := indexedPage{ID: id, Title: title, Page: page.Page, Text: page.Text}
+		if err := batch.Index(id+"/"+page.Page, doc); err != nil {
+			return fmt.Errorf("error indexing page %s: %w", page.Page, err)
+		}
+	}
+	if err := index.Batch(batch); err != nil {
+		return fmt.Errorf("error writing text index: %w", err)
+	}
+	return nil
+}
+
+// textResult is a page found by find-in-text
+type textResult struct {
+	page  indexedPage
+	score float64
+	match string // the first word found, as written on the page
+}
+
+// searchTextIndex returns up to limit pages containing any word of query,
+// best match first. Bleve ranks pages by how often the words occur on them
+// relative to the page length, with rarer words counting more.
+func searchTextIndex(index bleve.Index, query string, limit int) ([]textResult, error) {
+	match := bleve.NewMatchQuery(query)
+	match.SetField("text")
+	request := bleve.NewSearchRequestOptions(match, limit, 0, false)
+	request.Fields = []string{"id", "title", "page", "text"}
+	request.IncludeLocations = true
+	found, err := index.Search(request)
+	if err != nil {
+		return nil, fmt.Errorf("error searching text index: %w", err)
+	}
+
+	results := make([]textResult, 0, len(found.Hits))
+	for _, hit := range found.Hits {
+		field := func(name string) string {
+			s, _ := hit.Fields[name].(string)
+			return s
+		}
+		page := indexedPage{ID: field("id"), Title: field("title"), Page: field("page"), Text: field("text")}
+		// The words found may be inflected forms of the query words
+		var first *search.Location
+		for _, locations := range hit.Locations["text"] {
+			for _, l := range locations {
+				if first == nil || l.Start < first.Start {
+					first = l
+				}
+			}
+		}
+		var match string
+		if first != nil && first.End <= uint64(len(page.Text)) {
+			match = page.Text[first.Start:first.End]
+		}
+		results = append(results, textResult{page: page, score: hit.Score, match: match})
+	}
+	return results, nil
+}
+
+// runIndexText adds the page text of a book saved by -ocr-text to the
+// full-text index
+func runIndexText(args []string) int {
+	fs := flag.NewFlagSet("index-text", flag.ExitOnError)
+	bookID := fs.String("id", "", "Book ID, or output name if the book was saved under another one")
+	fs.Parse(args)
+
+	if *bookID == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the book to index with -id")
+		return 1
+	}
+
+	dir := nbdownloader.PageTextDir(*bookID)
+	pages, err := nbdownloader.ReadPageTexts(dir)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "No page text found in %s, download the book with -ocr-text first\n", dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// The title comes from the book index, if the book is there
+	title := *bookID
+	if entries, err := loadIndex(); err == nil {
+		for _, e := range entries {
+			if e.ID == *bookID && e.Title != "" {
+				title = e.Title
+				break
+			}
+		}
+	}
+
+	index, err := openTextIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer index.Close()
+	if err := setTextIndexBook(index, *bookID, title, pages); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Indexed %d pages of %s\n", len(pages), title)
+	return 0
+}
+
+// runFindInText searches the full-text index across all indexed books
+func runFindInText(args []string) int {
+	fs := flag.NewFlagSet("find-in-text", flag.ExitOnError)
+	query := fs.String("query", "", "Words to search for (case-insensitive)")
+	limit := fs.Int("limit", 20, "Maximum number of results")
+	fs.Parse(args)
+
+	if *query == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the words to search for with -query")
+		return 1
+	}
+
+	index, err := openTextIndex()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer index.Close()
+	results, err := searchTextIndex(index, *query, *limit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "No pages found matching %q\n", *query)
+		return 1
+	}
+
+	for n, r := range results {
+		// Show the whole query if it occurs on the page, else the first word
+		// found
+		snippet := nbdownloader.Snippet(r.page.Text, *query, searchContext)
+		if snippet == "" {
+			snippet = nbdownloader.Snippet(r.page.Text, r.match, searchContext)
+		}
+		book := r.page.ID
+		if r.page.Title != r.page.ID {
+			book = fmt.Sprintf("%s (%s)", r.page.Title, r.page.ID)
+		}
+		fmt.Printf("%d. %s, page %s  [score %.2f]\n", n+1, book, r.page.Page, r.score)
+		fmt.Printf("   %s\n", snippet)
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tlsCertValidity is how long a certificate generated by -tls-auto is valid
+const tlsCertValidity = 365 * 24 * time.Hour
+
+// loadTLSConfig returns the TLS configuration of the -ui server: the
+// certificate and key in certFile and keyFile, or with auto a self-signed
+// certificate for the host of addr. It returns nil to serve plain HTTP.
+func loadTLSConfig(certFile, keyFile string, auto bool, addr string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case auto:
+		cert, err = autoCertificate(addr)
+	case certFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// autoCertificate returns the self-signed certificate for -tls-auto, kept in
+// the config directory. A new one is generated if there is none yet, or if
+// it has expired or doesn't cover the host of addr.
+func autoCertificate(addr string) (tls.Certificate, error) {
+	dir, err := configDir()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPath := filepath.Join(dir, "tls-cert.pem")
+	keyPath := filepath.Join(dir, "tls-key.pem")
+
+	host, _, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil || time.Now().After(cert.Leaf.NotAfter) || cert.Leaf.VerifyHostname(host) != nil {
+		if err := generateCertificate(certPath, keyPath, host); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Println("Generated a self-signed certificate in", certPath)
+		if cert, err = tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	// Browsers warn about self-signed certificates, so show the fingerprint
+	// to compare with the one they display
+	fmt.Println("Certificate SHA-256 fingerprint:", certFingerprint(cert.Leaf))
+	return cert, nil
+}
+
+// generateCertificate writes a new self-signed certificate for localhost,
+// this computer's hostname and host to certPath, and its private key to
+// keyPath
+func generateCertificate(certPath, keyPath, host string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("error generating TLS certificate: %w", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "nb-downloader"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(tlsCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	hosts := []string{host}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip == nil {
+			if !slices.Contains(tmpl.DNSNames, h) {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		} else if !slices.ContainsFunc(tmpl.IPAddresses, ip.Equal) {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("error generating TLS certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding TLS key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+	// Only the user may read the key
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("error writing TLS key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("error writing TLS certificate: %w", err)
+	}
+	return nil
+}
+
+// certFingerprint returns the SHA-256 of a certificate as colon-separated
+// hex bytes, e.g. 3A:F1:...
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// tuiCellWidth is the width of a page cell in the grid, including the gap
+const tuiCellWidth = 6
+
+// tuiLogLines is the height of the log panel
+const tuiLogLines = 6
+
+// ANSI SGR attributes of the TUI elements
+const (
+	tuiPendingStyle = "37;100" // white on gray
+	tuiDoneStyle    = "30;42"  // black on green
+	tuiFailedStyle  = "97;41"  // bright white on red
+	tuiHeaderStyle  = "1"      // bold
+	tuiErrorStyle   = "31"     // red
+	tuiHelpStyle    = "2"      // faint
+)
+
+// styled wraps s in the ANSI SGR attributes style
+func styled(style, s string) string {
+	return "\033[" + style + "m" + s + "\033[0m"
+}
+
+// pageState is the download state of one cell in the page grid
+type pageState int
+
+const (
+	pagePending pageState = iota
+	pageDone
+	pageFailed
+)
+
+// tuiLogLine is one line in the log panel
+type tuiLogLine struct {
+	text  string
+	isErr bool
+}
+
+// Messages sent from the download to the TUI
+type (
+	tuiStartMsg []string
+	tuiPageMsg  nbdownloader.PageEvent
+	tuiLogMsg   string
+	tuiDoneMsg  struct{}
+)
+
+// tuiModel is the bubbletea model of the -tui download view. It shows a
+// grid with a cell per page, the overall progress and a log panel.
+type tuiModel struct {
+	book   *nbdownloader.Book
+	cancel context.CancelFunc
+	pages  []string
+	states map[string]pageState
+	last   nbdownloader.PageEvent
+	logs   []tuiLogLine
+	width  int
+	height int
+}
+
+// tuiSupported reports whether stdout is a terminal that can show the TUI
+func tuiSupported() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// downloadBookTUI downloads a book like downloadBook while showing the TUI.
+// The log is printed to stdout once the TUI has closed.
+func downloadBookTUI(parent context.Context, id string, opts nbdownloader.DownloadOptions) bool {
+	var program *tea.Program
+	send := func(msg tea.Msg) { program.Send(msg) }
+
+	// The TUI owns stdin, so confirmation prompts are declined
+	opts.Confirm = nil
+	opts.Log = tuiLog(send)
+	opts.OnStart = func(pageIDs []string) { send(tuiStartMsg(pageIDs)) }
+	opts.OnPage = func(e nbdownloader.PageEvent) { send(tuiPageMsg(e)) }
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	b := nbdownloader.NewBook(id, opts)
+	warnIfDownloaded(opts.Log, id, b.DocumentType())
+	program = tea.NewProgram(tuiModel{
+		book:   b,
+		cancel: cancel,
+		states: make(map[string]pageState),
+		width:  80,
+		height: 24,
+	}, tea.WithAltScreen())
+
+	finished := make(chan error, 1)
+	go func() {
+		err := b.Download(ctx)
+		finished <- err
+		send(tuiDoneMsg{})
+	}()
+
+	final, err := program.Run()
+	if err != nil {
+		fmt.Println("Error running terminal UI:", err)
+	}
+	cancel()
+	downloadErr := <-finished
+
+	if m, ok := final.(tuiModel); ok {
+		for _, line := range m.logs {
+			fmt.Println(line.text)
+		}
+	}
+	printRefreshedCookies(os.Stdout, b)
+	if downloadErr != nil {
+		fmt.Println(downloadErr)
+		if errors.Is(downloadErr, nbdownloader.ErrCancelled) && !opts.AssumeYes {
+			fmt.Println("Use -yes to continue without confirmation in -tui mode")
+		}
+		return false
+	}
+	recordInIndex(ctx, b)
+	recordHistory(b)
+	return true
+}
+
+// tuiLog passes the lines written by the downloader to the log panel
+type tuiLog func(msg tea.Msg)
+
+func (send tuiLog) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			send(tuiLogMsg(line))
+		}
+	}
+	return len(data), nil
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		// Keep the default size if the terminal does not report one
+		if msg.Width > 0 && msg.Height > 0 {
+			m.width, m.height = msg.Width, msg.Height
+		}
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			m.cancel()
+			return m, tea.Quit
+		}
+	case tuiStartMsg:
+		m.pages = msg
+	case tuiPageMsg:
+		m.last = nbdownloader.PageEvent(msg)
+		if msg.Err != nil {
+			m.states[msg.Page] = pageFailed
+			m.logs = append(m.logs, tuiLogLine{text: msg.Err.Error(), isErr: true})
+		} else {
+			m.states[msg.Page] = pageDone
+		}
+	case tuiLogMsg:
+		m.logs = append(m.logs, tuiLogLine{text: string(msg)})
+	case tuiDoneMsg:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m tuiModel) View() string {
+	var sb strings.Builder
+
+	header := fmt.Sprintf("Book %s (%s)", m.book.ID(), m.book.DocumentType())
+	if total := len(m.pages); total > 0 {
+		header += fmt.Sprintf("  %d/%d pages (%.1f%%)  %s/s  ETA %s",
+			m.last.Done, total, float64(m.last.Done)*100/float64(total),
+			nbdownloader.FormatBytes(int64(m.last.Rate)), m.last.ETA.Round(time.Second))
+	} else {
+		header += "  preparing download..."
+	}
+	sb.WriteString(styled(tuiHeaderStyle, truncate(header, m.width)) + "\n\n")
+
+	// Header, blank line, log title, log panel and help line
+	gridRows := max(m.height-4-tuiLogLines, 1)
+	sb.WriteString(m.viewGrid(gridRows))
+
+	sb.WriteString("\n" + styled(tuiHeaderStyle, "Log") + "\n")
+	logs := m.logs[max(len(m.logs)-tuiLogLines, 0):]
+	for _, line := range logs {
+		text := truncate(line.text, m.width)
+		if line.isErr {
+			text = styled(tuiErrorStyle, text)
+		}
+		sb.WriteString(text + "\n")
+	}
+	sb.WriteString(strings.Repeat("\n", tuiLogLines-len(logs)))
+	sb.WriteString(styled(tuiHelpStyle, "q: quit"))
+	return sb.String()
+}
+
+// viewGrid renders the page cells, scrolled so that the most recently
+// finished page is visible
+func (m tuiModel) viewGrid(rows int) string {
+	cols := max((m.width+1)/tuiCellWidth, 1)
+	lastRow := max(m.last.Done-1, 0) / cols
+	first := max(lastRow-rows+1, 0) * cols
+
+	var sb strings.Builder
+	for i := first; i < len(m.pages) && i < first+rows*cols; i++ {
+		page := m.pages[i]
+		cell := fmt.Sprintf(" %-4s", truncate(page, 4))
+		switch m.states[page] {
+		case pageDone:
+			cell = styled(tuiDoneStyle, cell)
+		case pageFailed:
+			cell = styled(tuiFailedStyle, cell)
+		default:
+			cell = styled(tuiPendingStyle, cell)
+		}
+		sb.WriteString(cell)
+		if (i+1)%cols == 0 || i == len(m.pages)-1 {
+			sb.WriteString("\n")
+		} else {
+			sb.WriteString(" ")
+		}
+	}
+	return sb.String()
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
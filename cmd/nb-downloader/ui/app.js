@@ -0,0 +1,167 @@
+"use strict";
+
+const form = document.getElementById("start");
+const fields = form.elements;
+const errorText = document.getElementById("error");
+const issueDate = document.getElementById("issue-date");
+
+// The API key of a server started with -api-key, asked for when needed
+let apiKey = sessionStorage.getItem("apiKey") || "";
+
+// api sends a request to the API with the API key, asking for the key if
+// the server refuses it
+async function api(path, options = {}) {
+  for (;;) {
+    const headers = { ...options.headers };
+    if (apiKey) {
+      headers.Authorization = `Bearer ${apiKey}`;
+    }
+    const response = await fetch(path, { ...options, headers });
+    if (response.status !== 401) {
+      return response;
+    }
+    const key = prompt(apiKey ? "Wrong API key, try again:" : "API key:");
+    if (!key) {
+      return response;
+    }
+    apiKey = key;
+    sessionStorage.setItem("apiKey", key);
+  }
+}
+
+// streamEvents reads the Server-Sent Events of path and calls the handler
+// named after each event with its data. EventSource can't be used, as it
+// can't send the API key.
+async function streamEvents(path, handlers) {
+  const response = await api(path);
+  if (!response.ok) {
+    handlers.done({ ok: false, error: await response.text() });
+    return;
+  }
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += value;
+    let end;
+    while ((end = buffer.indexOf("\n\n")) >= 0) {
+      const event = {};
+      for (const line of buffer.slice(0, end).split("\n")) {
+        const i = line.indexOf(": ");
+        event[line.slice(0, i)] = line.slice(i + 2);
+      }
+      buffer = buffer.slice(end + 2);
+      handlers[event.event]?.(JSON.parse(event.data));
+    }
+  }
+}
+
+// save downloads the output file of a job
+async function save(job, file) {
+  const response = await api(`api/downloads/${job}/file`);
+  if (!response.ok) {
+    alert(await response.text());
+    return;
+  }
+  const link = document.createElement("a");
+  link.href = URL.createObjectURL(await response.blob());
+  link.download = file;
+  link.click();
+  URL.revokeObjectURL(link.href);
+}
+
+// Newspapers and periodicals are downloaded by issue
+fields.type.addEventListener("change", () => {
+  issueDate.hidden = !["avis", "tidsskrift"].includes(fields.type.value);
+});
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  errorText.hidden = true;
+  const request = {
+    id: fields.id.value.trim(),
+    type: fields.type.value,
+    issueDate: issueDate.hidden ? "" : fields.issueDate.value,
+    format: fields.format.value,
+  };
+  const response = await api("api/downloads", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(request),
+  });
+  if (!response.ok) {
+    errorText.textContent = await response.text();
+    errorText.hidden = false;
+    return;
+  }
+  const { job } = await response.json();
+  follow(job, request.id);
+  fields.id.value = "";
+});
+
+// follow shows the progress of a download as its events arrive
+function follow(job, bookID) {
+  const card = document.getElementById("download").content.firstElementChild.cloneNode(true);
+  const status = card.querySelector(".status");
+  const progress = card.querySelector("progress");
+  const stats = card.querySelector(".stats");
+  const log = card.querySelector(".log");
+  card.querySelector(".book").textContent = bookID;
+  document.getElementById("downloads").prepend(card);
+
+  streamEvents(`api/progress/${job}`, {
+    start(start) {
+      progress.max = start.pages.length;
+      status.textContent = "Starting…";
+    },
+    log(line) {
+      log.textContent += line.message + "\n";
+    },
+    page(page) {
+      progress.max = page.total;
+      progress.value = page.done;
+      status.textContent = `${page.done}/${page.total} pages`;
+      stats.textContent = `${formatRate(page.rate ?? 0)} · ${formatDuration(page.eta ?? 0)} left`;
+      if (page.error) {
+        log.textContent += page.error + "\n";
+      }
+    },
+    done(done) {
+      stats.textContent = "";
+      if (!done.ok) {
+        card.classList.add("failed");
+        status.textContent = done.error;
+        return;
+      }
+      progress.value = progress.max;
+      status.textContent = "";
+      const link = document.createElement("a");
+      link.href = "#";
+      link.textContent = `Save ${done.file}`;
+      link.addEventListener("click", (event) => {
+        event.preventDefault();
+        save(job, done.file);
+      });
+      status.append(link);
+    },
+  });
+}
+
+function formatRate(bytesPerSecond) {
+  const units = ["B/s", "KB/s", "MB/s", "GB/s"];
+  let i = 0;
+  while (bytesPerSecond >= 1024 && i < units.length - 1) {
+    bytesPerSecond /= 1024;
+    i++;
+  }
+  return `${bytesPerSecond.toFixed(1)} ${units[i]}`;
+}
+
+function formatDuration(seconds) {
+  seconds = Math.round(seconds);
+  const minutes = Math.floor(seconds / 60);
+  return minutes > 0 ? `${minutes}m ${seconds % 60}s` : `${seconds}s`;
+}
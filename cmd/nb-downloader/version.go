@@ -0,0 +1,45 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// Build information, set with -ldflags "-X main.version=..." by the Makefile
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// printVersion prints the version, commit and build date for -version.
+// Without build-time values they are taken from the version control
+// information Go records in the binary.
+func printVersion() {
+	vcsRevision, vcsTime, modified := "", "", false
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				vcsRevision = s.Value
+			case "vcs.time":
+				vcsTime = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if modified {
+		vcsRevision += "-dirty"
+	}
+
+	fmt.Printf("nb-downloader %s\n", cmp.Or(version, nbdownloader.Version(), "(devel)"))
+	fmt.Printf("  commit:   %s\n", cmp.Or(commit, vcsRevision, "unknown"))
+	fmt.Printf("  built:    %s\n", cmp.Or(buildDate, vcsTime, "unknown"))
+	fmt.Printf("  go:       %s\n", runtime.Version())
+	fmt.Printf("  platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
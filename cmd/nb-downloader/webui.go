@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// uiFiles holds the HTML, CSS and JavaScript of the web UI
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiQueueSize is the number of downloads that can wait for a worker
+const uiQueueSize = 100
+
+// uiFormats lists the -format values the web UI offers, those that produce
+// a single file the browser can download
+var uiFormats = []string{"pdf", "epub"}
+
+// DownloadEvent is a step of a download started from the web UI, sent to
+// the browser as a Server-Sent Event named after its type:
+//
+//   - "start" when the pages to download are known
+//   - "page" when a page has been downloaded or has failed
+//   - "log" with a line of the download log
+//   - "done" when the download has finished, successfully or not
+type DownloadEvent struct {
+	Type    string   `json:"type"`
+	Pages   []string `json:"pages,omitempty"`   // start: the page IDs
+	Page    string   `json:"page,omitempty"`    // page: the page ID
+	Done    int      `json:"done,omitempty"`    // page: pages finished so far
+	Total   int      `json:"total,omitempty"`   // page: pages in the download
+	Rate    float64  `json:"rate,omitempty"`    // page: bytes per second
+	ETA     float64  `json:"eta,omitempty"`     // page: seconds left
+	Message string   `json:"message,omitempty"` // log: the line
+	OK      bool     `json:"ok,omitempty"`      // done: whether the download succeeded
+	File    string   `json:"file,omitempty"`    // done: name of the output file
+	Error   string   `json:"error,omitempty"`   // page, done: what went wrong
+}
+
+// uiJob is a download started from the web UI. Its events are kept, so
+// that a page opened later still sees the whole download.
+type uiJob struct {
+	id     string
+	bookID string
+
+	mu      sync.Mutex
+	events  []DownloadEvent
+	changed chan struct{} // closed and replaced when an event is added
+	status  string        // "queued", "running", "done" or "failed"
+	err     string        // why the download failed
+	done    bool
+	outPath string
+	book    *nbdownloader.Book // the running download, for its page counts
+}
+
+func newUIJob(id, bookID string) *uiJob {
+	return &uiJob{id: id, bookID: bookID, changed: make(chan struct{}), status: "queued"}
+}
+
+// setStatus changes the status of the job
+func (j *uiJob) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+// add records an event and wakes up the event streams
+func (j *uiJob) add(e DownloadEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, e)
+	if e.Type == "done" {
+		j.done = true
+		j.status, j.err = "done", e.Error
+		if !e.OK {
+			j.status = "failed"
+		}
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// eventsFrom returns the events after the first n, whether the job is done
+// and a channel that is closed when the next event is added
+func (j *uiJob) eventsFrom(n int) ([]DownloadEvent, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.events[n:], j.done, j.changed
+}
+
+// Write passes the lines of the download log on as "log" events
+func (j *uiJob) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			j.add(DownloadEvent{Type: "log", Message: line})
+		}
+	}
+	return len(data), nil
+}
+
+// uiServer runs the downloads started from the web UI
+type uiServer struct {
+	ctx        context.Context // cancels all downloads
+	opts       nbdownloader.DownloadOptions
+	onConflict string
+
+	jobQueue chan downloadJob // downloads waiting for a worker
+
+	mu     sync.Mutex
+	jobs   map[string]*uiJob
+	nextID int
+}
+
+// downloadJob is a download waiting in the queue
+type downloadJob struct {
+	job  *uiJob
+	opts nbdownloader.DownloadOptions
+}
+
+// serveUI serves the web UI on addr until ctx is cancelled. Downloads use
+// opts, with the book ID, type and format chosen in the UI, and run up to
+// workers at a time; the others wait in a queue. If apiKey is set, API
+// requests must present it as a bearer token. With tlsConfig the UI is
+// served over HTTPS.
+func serveUI(ctx context.Context, addr string, opts nbdownloader.DownloadOptions, onConflict string, workers int, apiKey string, tlsConfig *tls.Config) error {
+	// Downloads running at the same time share the bandwidth limit, and
+	// questions can't be asked in the browser
+	opts.Limiter = nbdownloader.NewLimiter(opts.Bandwidth)
+	opts.Confirm = nil
+	s := &uiServer{
+		ctx:        ctx,
+		opts:       opts,
+		onConflict: onConflict,
+		jobQueue:   make(chan downloadJob, uiQueueSize),
+		jobs:       make(map[string]*uiJob),
+	}
+	for range workers {
+		go s.worker(ctx, s.jobQueue)
+	}
+
+	static, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		return err
+	}
+	// Starting a download costs more than asking how one is going, so
+	// starts have the lower limit
+	starts := newIPRateLimiter(startRateLimit)
+	polls := newIPRateLimiter(pollRateLimit)
+	api := http.NewServeMux()
+	api.Handle("POST /api/downloads", starts.middleware(http.HandlerFunc(s.handleStart)))
+	api.Handle("GET /api/progress/{job}", polls.middleware(http.HandlerFunc(s.handleProgress)))
+	api.Handle("GET /api/downloads/{job}/file", polls.middleware(http.HandlerFunc(s.handleFile)))
+	api.Handle("GET /api/queue", polls.middleware(http.HandlerFunc(s.handleQueue)))
+
+	// The page itself holds no secrets, it asks for the key when the API
+	// refuses a request
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(static))
+	mux.Handle("/api/", apiKeyMiddleware(apiKey)(api))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tcp, ok := listener.Addr().(*net.TCPAddr); ok && !tcp.IP.IsLoopback() && apiKey == "" {
+		fmt.Fprintln(os.Stderr, "WARNING: the web UI is reachable from other computers without -api-key,")
+		fmt.Fprintln(os.Stderr, "so anyone on the network can start downloads with your cookies.")
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Web UI running at %s://%s/, press Ctrl+C to stop\n", scheme, listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// apiKeyMiddleware returns a middleware that refuses requests without the
+// header "Authorization: Bearer <key>" with 401 Unauthorized. An empty key
+// lets all requests through.
+func apiKeyMiddleware(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="nb-downloader"`)
+				http.Error(w, "missing or wrong API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleStart starts the download of the book in the JSON request body
+func (s *uiServer) handleStart(w http.ResponseWriter, r *http.Request) {
+	// Only the UI's own page may start downloads, not other web sites the
+	// browser has open
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+	}
+
+	var req struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"`
+		IssueDate string `json:"issueDate"`
+		Format    string `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" {
+		http.Error(w, "no book ID given", http.StatusBadRequest)
+		return
+	}
+	if err := nbdownloader.ValidateDocumentType(req.Type); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := nbdownloader.ValidateIssueDate(req.Type, req.IssueDate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := nbdownloader.ValidateBookID(req.ID, req.Type); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(uiFormats, req.Format) {
+		http.Error(w, fmt.Sprintf("unknown format %q", req.Format), http.StatusBadRequest)
+		return
+	}
+
+	opts := s.opts
+	opts.IssueDate = req.IssueDate
+	opts = typeOptions(opts, req.Type)
+	opts.Format = req.Format
+
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		if _, done, _ := job.eventsFrom(0); job.bookID == req.ID && !done {
+			s.mu.Unlock()
+			http.Error(w, "book "+req.ID+" is already being downloaded", http.StatusConflict)
+			return
+		}
+	}
+	job := newUIJob(strconv.Itoa(s.nextID+1), req.ID)
+	select {
+	case s.jobQueue <- downloadJob{job, opts}:
+	default:
+		s.mu.Unlock()
+		http.Error(w, "too many downloads waiting, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	s.nextID++
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"job": job.id})
+}
+
+// worker downloads the books queued in q one after the other until ctx is
+// cancelled. Books still queued then fail.
+func (s *uiServer) worker(ctx context.Context, q <-chan downloadJob) {
+	for {
+		select {
+		case d := <-q:
+			if ctx.Err() != nil {
+				d.job.add(DownloadEvent{Type: "done", Error: ctx.Err().Error()})
+				continue
+			}
+			d.job.setStatus("running")
+			s.run(d.job, d.opts)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run downloads the book of job and records it like a download from the
+// command line
+func (s *uiServer) run(job *uiJob, opts nbdownloader.DownloadOptions) {
+	opts.Log = job
+	opts.OnStart = func(pageIDs []string) {
+		job.add(DownloadEvent{Type: "start", Pages: pageIDs})
+	}
+	opts.OnPage = func(e nbdownloader.PageEvent) {
+		event := DownloadEvent{Type: "page", Page: e.Page, Done: e.Done, Total: e.Total, Rate: e.Rate, ETA: e.ETA.Seconds()}
+		if e.Err != nil {
+			event.Error = e.Err.Error()
+		}
+		job.add(event)
+	}
+
+	opts, proceed, err := resolveConflict(job.bookID, opts, s.onConflict, false)
+	if err != nil {
+		job.add(DownloadEvent{Type: "done", Error: err.Error()})
+		return
+	}
+	if !proceed {
+		// -on-conflict skip offers the existing file
+		s.finish(job, targetPath(job.bookID, opts, false))
+		return
+	}
+
+	b := nbdownloader.NewBook(job.bookID, opts)
+	job.mu.Lock()
+	job.book = b
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.book = nil
+		job.mu.Unlock()
+	}()
+	warnIfDownloaded(job, job.bookID, b.DocumentType())
+	if err := b.Download(s.ctx); err != nil {
+		job.add(DownloadEvent{Type: "done", Error: err.Error()})
+		return
+	}
+	recordInIndex(s.ctx, b)
+	recordHistory(b)
+	s.finish(job, b.OutputPath())
+}
+
+// finish marks the job as done with outPath to download
+func (s *uiServer) finish(job *uiJob, outPath string) {
+	job.mu.Lock()
+	job.outPath = outPath
+	job.mu.Unlock()
+	job.add(DownloadEvent{Type: "done", OK: true, File: filepath.Base(outPath)})
+}
+
+// job returns the job named in the request path, or writes a 404
+func (s *uiServer) job(w http.ResponseWriter, r *http.Request) (*uiJob, bool) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("job")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+	}
+	return job, ok
+}
+
+// handleProgress streams the events of a download, from its start, as
+// Server-Sent Events with the DownloadEvent as JSON data. The stream ends
+// after the "done" event.
+func (s *uiServer) handleProgress(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	sent := 0
+	for {
+		events, done, changed := job.eventsFrom(sent)
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+		sent += len(events)
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// uiJobStatus describes a job in the answer of /api/queue
+type uiJobStatus struct {
+	Job    string `json:"job"`
+	ID     string `json:"id"` // book ID
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Done   int    `json:"done,omitempty"`   // running: pages finished so far
+	Total  int    `json:"total,omitempty"`  // running: pages in the download
+	Failed int    `json:"failed,omitempty"` // running: pages that failed
+	Bytes  int64  `json:"bytes,omitempty"`  // running: bytes received
+}
+
+// handleQueue reports the number of queued downloads and the status of the
+// running and finished ones, in the order they were started
+func (s *uiServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	var answer struct {
+		Queued    int           `json:"queued"`
+		Active    []uiJobStatus `json:"active"`
+		Completed []uiJobStatus `json:"completed"`
+	}
+	answer.Queued = len(s.jobQueue)
+	answer.Active, answer.Completed = []uiJobStatus{}, []uiJobStatus{}
+
+	// Jobs are numbered from 1 in the order they were started
+	s.mu.Lock()
+	var jobs []*uiJob
+	for n := 1; n <= s.nextID; n++ {
+		jobs = append(jobs, s.jobs[strconv.Itoa(n)])
+	}
+	s.mu.Unlock()
+	for _, job := range jobs {
+		job.mu.Lock()
+		status := uiJobStatus{Job: job.id, ID: job.bookID, Status: job.status, Error: job.err}
+		book := job.book
+		job.mu.Unlock()
+		switch status.Status {
+		case "running":
+			if book != nil {
+				stats := book.Stats()
+				status.Done, status.Total, status.Failed, status.Bytes = stats.Done, stats.Total, stats.Failed, stats.Bytes
+			}
+			answer.Active = append(answer.Active, status)
+		case "done", "failed":
+			answer.Completed = append(answer.Completed, status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
+
+// handleFile sends the output file of a finished download
+func (s *uiServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	job.mu.Lock()
+	path := job.outPath
+	job.mu.Unlock()
+	if info, err := os.Stat(path); path == "" || err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "the download has no file", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
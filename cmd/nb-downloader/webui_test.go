@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		key    string
+		header string
+		status int
+	}{
+		{"no key configured", "", "", http.StatusNoContent},
+		{"no key configured, header sent", "", "Bearer anything", http.StatusNoContent},
+		{"right key", "s3cret", "Bearer s3cret", http.StatusNoContent},
+		{"no header", "s3cret", "", http.StatusUnauthorized},
+		{"wrong key", "s3cret", "Bearer s3cret2", http.StatusUnauthorized},
+		{"key without scheme", "s3cret", "s3cret", http.StatusUnauthorized},
+		{"basic auth", "s3cret", "Basic czNjcmV0", http.StatusUnauthorized},
+		{"lowercase scheme", "s3cret", "bearer s3cret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/downloads", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		apiKeyMiddleware(tt.key)(ok).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if tt.status == http.StatusUnauthorized && challenge != `Bearer realm="nb-downloader"` {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, challenge)
+		}
+	}
+}
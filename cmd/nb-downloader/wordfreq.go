@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+// norwegianStopWords lists common Norwegian function words, bokmål and
+// nynorsk, that are left out of word frequencies
+var norwegianStopWords = makeSet(strings.Fields(`
+	alle andre at av bare begge ble blei bli blir blitt både båe da de dei
+	deim deira deires dem den denne dens der dere deres det dette di din
+	disse ditt du dykk dykkar då eg ein eit eitt eller elles en enn er et
+	ett etter for fordi fra før ha hadde han hans har hennar henne hennes
+	her hjå ho hoe honom hoss hossen hun hva hvem hver hvilke hvilken hvis
+	hvor hvordan hvorfor i ikke ikkje ingen ingi inkje inn inni ja jeg kan
+	kom korleis korso kun kunne kva kvar kvarhelst kven kvi kvifor man mange
+	me med medan meg meget mellom men mi min mine mitt mot mykje ned no noe
+	noen noka noko nokon nokor nokre nå når og også om opp oss over på
+	samme seg selv si sia sidan siden sin sine sitt sjøl skal skulle slik so
+	som somme somt så sånn til um upp ut uten var vart varte ved vere verte
+	vi vil ville vore vors vort vår være vært å
+`))
+
+// makeSet returns the words as a set
+func makeSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// wordCount is the number of occurrences of a word
+type wordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// wordFrequencies counts the words of the texts regardless of case, leaving
+// out stop words, and returns the top most frequent, ties in alphabetical
+// order. Words are split at every character that is not a letter.
+func wordFrequencies(texts []string, top int) []wordCount {
+	counts := make(map[string]int)
+	for _, text := range texts {
+		for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			if !norwegianStopWords[w] {
+				counts[w]++
+			}
+		}
+	}
+
+	freqs := make([]wordCount, 0, len(counts))
+	for w, n := range counts {
+		freqs = append(freqs, wordCount{Word: w, Count: n})
+	}
+	slices.SortFunc(freqs, func(a, b wordCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Word, b.Word))
+	})
+	return freqs[:min(len(freqs), top)]
+}
+
+// runWordFreq prints the most frequent words in the page text of a book
+// saved by -ocr-text and saves them next to it
+func runWordFreq(args []string) int {
+	fs := flag.NewFlagSet("wordfreq", flag.ExitOnError)
+	bookID := fs.String("id", "", "Book ID, or output name if the book was saved under another one")
+	format := fs.String("format", "csv", "Output format: 'csv' or 'json'")
+	top := fs.Int("top", 100, "Number of words to list")
+	fs.Parse(args)
+
+	if *bookID == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the book with -id")
+		return 1
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected 'csv' or 'json'\n", *format)
+		return 1
+	}
+
+	dir := nbdownloader.PageTextDir(*bookID)
+	pages, err := nbdownloader.ReadPageTexts(dir)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "No page text found in %s, download the book with -ocr-text first\n", dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	texts := make([]string, len(pages))
+	for i, page := range pages {
+		texts[i] = page.Text
+	}
+	freqs := wordFrequencies(texts, *top)
+
+	var out bytes.Buffer
+	if *format == "json" {
+		data, err := json.MarshalIndent(freqs, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out.Write(append(data, '\n'))
+	} else {
+		w := csv.NewWriter(&out)
+		w.Write([]string{"word", "count"})
+		for _, f := range freqs {
+			w.Write([]string{f.Word, strconv.Itoa(f.Count)})
+		}
+		w.Flush()
+	}
+
+	fmt.Print(out.String())
+	outPath := *bookID + "_wordfreq." + *format
+	if err := os.WriteFile(outPath, out.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error saving word frequencies:", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Word frequencies saved to", outPath)
+	return 0
+}
@@ -0,0 +1,325 @@
+package nbdownloader
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AudioDocumentType is the nb.no document type of audio books and music
+// recordings, which are downloaded with AudioBook instead of Book
+const AudioDocumentType = "lyd"
+
+// itemURLTemplate points at the catalog record of an audio recording
+const itemURLTemplate = "{api_base_url}/items/URN:NBN:no-nb_lyd_{id}"
+
+// audioExtensions lists the file types that are downloaded from a recording
+var audioExtensions = []string{".mp3", ".flac"}
+
+// AudioBook represents an audio book or music recording to be downloaded
+type AudioBook struct {
+	id         string
+	name       string // base name of the output file or folder
+	apiBaseURL string // catalog API serving the catalog record
+	client     *http.Client
+	bandwidth  *tokenBucket // download rate limit, nil for none
+	cookies    []*http.Cookie
+	format     string // "mp3-zip" for a ZIP archive, otherwise the files are kept
+	fullpath   string // temporary folder the files are downloaded to
+	log        io.Writer
+	outPath    string   // output file or folder of the last download
+	tracks     []string // files written by the last download
+}
+
+// NewAudioBook creates a new AudioBook. Of the options only Cookies,
+// TempDir, OutputName, APIBaseURL, Format, Bandwidth, Limiter, Proxy,
+// RootCAs, Insecure and Log apply;
+// Format "mp3-zip" saves the files as a ZIP archive.
+func NewAudioBook(id string, opts DownloadOptions) *AudioBook {
+	log := opts.Log
+	if log == nil {
+		log = io.Discard
+	}
+	return &AudioBook{
+		id:         id,
+		name:       cmp.Or(opts.OutputName, id),
+		apiBaseURL: opts.apiBaseURL(),
+		client:     newHTTPClient(opts),
+		bandwidth:  opts.limiter().bandwidth,
+		cookies:    opts.Cookies,
+		format:     opts.Format,
+		fullpath:   filepath.Join(opts.TempDir, id+"_temp_audio_folder"),
+		log:        log,
+	}
+}
+
+// ID returns the recording ID
+func (a *AudioBook) ID() string {
+	return a.id
+}
+
+// OutputPath returns the ZIP archive or folder written by the last
+// successful Download, or "" if there is none
+func (a *AudioBook) OutputPath() string {
+	return a.outPath
+}
+
+// TargetPath returns the ZIP archive or folder that Download will write
+func (a *AudioBook) TargetPath() string {
+	if a.format == "mp3-zip" {
+		return a.name + ".zip"
+	}
+	return a.name + "_audio"
+}
+
+// TrackCount returns the number of files saved by the last Download
+func (a *AudioBook) TrackCount() int {
+	return len(a.tracks)
+}
+
+// itemURL returns the catalog URL of the recording
+func (a *AudioBook) itemURL() string {
+	u := strings.Replace(itemURLTemplate, "{api_base_url}", a.apiBaseURL, 1)
+	return strings.Replace(u, "{id}", a.id, 1)
+}
+
+// get sends a GET request with the authentication cookies for the host
+func (a *AudioBook) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.cookies) > 0 {
+		a.client.Jar.SetCookies(req.URL, a.cookies)
+	}
+	return a.client.Do(req)
+}
+
+// TrackURLs fetches the catalog record of the recording and returns the
+// URLs of its MP3 and FLAC files in the order they are listed
+func (a *AudioBook) TrackURLs(ctx context.Context) ([]string, error) {
+	resp, err := a.get(ctx, a.itemURL())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching catalog record: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching catalog record: HTTP Status %d", resp.StatusCode)
+	}
+
+	urls, err := findAudioURLs(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing catalog record: %w", err)
+	}
+	return urls, nil
+}
+
+// findAudioURLs returns every distinct URL in a JSON document whose path
+// ends in one of audioExtensions. The layout of the catalog record differs
+// between recordings, so the whole document is searched rather than
+// particular fields.
+func findAudioURLs(r io.Reader) ([]string, error) {
+	dec := json.NewDecoder(r)
+	var urls []string
+	seen := make(map[string]bool)
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, ok := token.(string)
+		if !ok || seen[s] || !isAudioURL(s) {
+			continue
+		}
+		seen[s] = true
+		urls = append(urls, s)
+	}
+}
+
+// isAudioURL reports whether s is an http(s) URL of an audio file
+func isAudioURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, e := range audioExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Download fetches the files of the recording. They are saved to the folder
+// <id>_audio, or with the "mp3-zip" format to the archive <id>.zip. Any
+// failed file aborts the download, since a recording with missing tracks is
+// of little use.
+func (a *AudioBook) Download(ctx context.Context) error {
+	a.outPath, a.tracks = "", nil
+
+	urls, err := a.TrackURLs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("no audio files found for recording %s", a.id)
+	}
+
+	if err := os.MkdirAll(a.fullpath, 0755); err != nil {
+		return &StorageError{Path: a.fullpath, Err: err}
+	}
+
+	fmt.Fprintf(a.log, "Downloading recording %s (%d files)\n", a.id, len(urls))
+	var tracks []string
+	for i, trackURL := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := trackFileName(i+1, trackURL)
+		fmt.Fprintf(a.log, "Downloading file %d/%d: %s\n", i+1, len(urls), name)
+		trackPath := filepath.Join(a.fullpath, name)
+		if err := a.downloadTrack(ctx, trackURL, trackPath); err != nil {
+			return fmt.Errorf("aborting download: %w", err)
+		}
+		tracks = append(tracks, trackPath)
+	}
+
+	var outPath string
+	if a.format == "mp3-zip" {
+		outPath, err = a.saveZip(tracks)
+	} else {
+		outPath, err = a.saveFolder()
+	}
+	if err != nil {
+		return err
+	}
+	a.outPath, a.tracks = outPath, tracks
+	fmt.Fprintf(a.log, "Saved %d audio files of recording %s to %s\n", len(tracks), a.id, outPath)
+	return nil
+}
+
+// trackFileName names the n-th file after the last element of its URL path,
+// prefixed with its position so that the files sort in order
+func trackFileName(n int, trackURL string) string {
+	u, _ := url.Parse(trackURL)
+	return fmt.Sprintf("%03d_%s", n, path.Base(u.Path))
+}
+
+// downloadTrack saves one audio file to outPath
+func (a *AudioBook) downloadTrack(ctx context.Context, trackURL, outPath string) error {
+	name := filepath.Base(outPath)
+	resp, err := a.get(ctx, trackURL)
+	if err != nil {
+		return &NetworkError{Page: name, URL: trackURL, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &AuthError{Page: name, StatusCode: resp.StatusCode}
+	case resp.StatusCode == http.StatusNotFound:
+		return &PageNotFoundError{Page: name, URL: trackURL}
+	case resp.StatusCode != http.StatusOK:
+		return &NetworkError{Page: name, URL: trackURL, StatusCode: resp.StatusCode}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return &StorageError{Path: outPath, Err: err}
+	}
+	if _, err := io.Copy(out, throttle(ctx, resp.Body, a.bandwidth)); err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return &NetworkError{Page: name, URL: trackURL, Err: err}
+	}
+	if err := out.Close(); err != nil {
+		return &StorageError{Path: outPath, Err: err}
+	}
+	return nil
+}
+
+// saveFolder moves the downloaded files to <name>_audio
+func (a *AudioBook) saveFolder() (string, error) {
+	outDir := a.TargetPath()
+	if err := os.Rename(a.fullpath, outDir); err != nil {
+		return "", fmt.Errorf("error renaming audio folder: %w", err)
+	}
+	return outDir, nil
+}
+
+// saveZip stores the downloaded files in <name>.zip and removes the temporary
+// folder. Audio files are already compressed, so they are stored as they
+// are. Like PDFs the archive is written to a .tmp file first.
+func (a *AudioBook) saveZip(tracks []string) (string, error) {
+	outPath := a.TargetPath()
+	tmpPath := outPath + ".tmp"
+	if err := writeZip(tmpPath, tracks); err != nil {
+		os.Remove(tmpPath)
+		return "", &StorageError{Path: outPath, Err: err}
+	}
+	if err := replaceFile(tmpPath, outPath); err != nil {
+		return "", &StorageError{Path: outPath, Err: err}
+	}
+	os.RemoveAll(a.fullpath)
+	return outPath, nil
+}
+
+// writeZip writes the files to a ZIP archive at zipPath
+func writeZip(zipPath string, files []string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, file := range files {
+		if err := addToZip(zw, file); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// addToZip stores a file in the archive under its base name
+func addToZip(zw *zip.Writer, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Store
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -0,0 +1,1664 @@
+package nbdownloader
+
+import (
+	"cmp"
+	"compress/zlib"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Book represents a book to be downloaded
+type Book struct {
+	// mu guards the fields that other goroutines may read or write while
+	// the book downloads: what was found out about the book (length,
+	// pageNrWidth and the manifest and metadata caches), last
+	// and report, and the results, progress and pageErrors of the download
+	// in last. It is a pointer so that Clone can copy the rest of the Book.
+	mu               *sync.RWMutex
+	id               string
+	name             string // base name of the output files
+	length           int
+	path             string
+	fullpath         string
+	baseURL          string
+	apiBaseURL       string // catalog API serving the IIIF manifests
+	urlTemplate      string
+	client           *http.Client // shared with clones of the book
+	documentType     string       // "digibok", "pliktmonografi", "avis" or "tidsskrift"
+	issueDate        string       // YYYY-MM-DD date of a newspaper or periodical issue
+	format           string       // "pdf", "epub", "images" or "none"
+	imageWidth       int
+	cropMargin       CropMargin
+	imageFormat      string // "jpg" or "png", also the file extension of the pages
+	assumeYes        bool   // skip confirmation prompts
+	confirm          func(question string) bool
+	metadata         *Metadata
+	manifest         *IIIFManifest // IIIF manifest, once fetched
+	manifestErr      error         // why the manifest couldn't be fetched
+	skipVerify       bool          // don't check downloaded images for corruption
+	compressLevel    int           // zlib level of PDF page streams, 0 for none
+	splitSize        int           // maximum pages per PDF part, 0 for a single PDF
+	startPage        int           // first numbered page to download, 0 for the first page
+	endPage          int           // last numbered page to download, 0 for the last page
+	noCovers         bool          // skip cover and introduction pages
+	colorSpace       string        // "rgb", "gray" or "cmyk"
+	jsonProgress     bool          // report progress as JSON lines
+	stripEXIF        bool          // re-encode pages without EXIF metadata
+	spineShadow      bool          // brighten the shadow along the spine edge
+	whiteBackground  bool          // level the paper color to white
+	denoise          bool          // apply a median filter to the pages
+	denoiseRadius    int           // median filter radius in pixels
+	sharpen          bool          // apply an unsharp mask to the pages
+	sharpenAmount    float64       // strength of the unsharp mask
+	sharpenRadius    float64       // blur radius of the unsharp mask in pixels
+	sharpenThreshold float64       // minimum difference to sharpen, 0-255
+	binarize         bool          // convert the pages to black and white
+	binarizeWindow   int           // window size of the adaptive threshold in pixels
+	binarizeK        float64       // Sauvola k parameter
+	autoMargin       bool          // crop the pages to their text area and add margins
+	margin           float64       // margin around the text area in mm
+	padding          int           // border added around the pages in pixels
+	paddingColor     color.Color   // color of the border
+	onStart          func(pageIDs []string)
+	onPage           func(PageEvent)
+	log              io.Writer
+	bandwidth        *tokenBucket // shared download rate limit, nil for none
+	limiter          *Limiter     // holds back requests after HTTP 429
+	cache            *pageCache   // downloaded page images, nil for none
+	coverTemplate    string       // HTML template rendered as the first page
+	headerTOC        bool         // bookmark chapters found from running headers
+	inferPageNumbers bool         // read the printed page numbers by OCR
+	ocrText          bool         // save the OCR text with joined paragraphs
+	sidecar          bool         // describe the download in <name>.json
+	retryFailed      bool         // download only the pages the state file lists as missing
+	failOnDuplicates bool         // abort when consecutive pages are the same image
+	ner              bool         // save the named entities of the OCR text
+	nerModel         string       // spaCy model that finds the named entities
+	pageNrWidth      int          // digits of numbered pages in URLs, 0 until detected
+	last             *download    // the running or last Download, nil before the first
+	report           *Report
+	writeReport      bool // save the report as <bookID>_report.json
+	cookies          *sessionCookies
+}
+
+// download is the state of one run of Download. Every run gets its own, so
+// that the Book holds only its configuration and what was found out about
+// the book, and can be downloaded again, or cloned while it downloads. The
+// state of a page being downloaded is kept in its pageContext.
+type download struct {
+	*Book
+	client      *http.Client // b.client, or a copy without the cookies for a public book
+	urlTemplate string       // b.urlTemplate with the crop region, once it is resolved
+	progress    *progress
+	outPath     string            // output file or folder
+	outFiles    []string          // files or folders written
+	pageCount   int               // pages in the output
+	pages       []string          // page images in reading order
+	pageErrors  []error           // pages that could not be downloaded
+	attempted   int               // pages requested
+	skipped     int               // pages of an earlier run not requested again
+	pageNumbers map[string]string // printed page numbers by page ID, with inferPageNumbers
+	bookmarks   map[string]string // bookmark titles by page image, with headerTOC
+}
+
+// newDownload prepares a run of Download or the download of single pages
+func (b *Book) newDownload() *download {
+	return &download{Book: b, client: b.client, urlTemplate: b.urlTemplate}
+}
+
+// lastResult returns the output path, page count and page images of the
+// last Download, which are empty before the first or while one runs
+func (b *Book) lastResult() (outPath string, pageCount int, pages []string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.last == nil {
+		return "", 0, nil
+	}
+	return b.last.outPath, b.last.pageCount, b.last.pages
+}
+
+// DownloadOptions configures a Book. The zero value downloads a digibok from
+// nb.no as a single compressed PDF with 602px wide pages.
+type DownloadOptions struct {
+	Length           int                    // number of numbered pages, 0 to find out by probing
+	DocumentType     string                 // "digibok" (default), "pliktmonografi", "avis" or "tidsskrift"
+	IssueDate        string                 // YYYY-MM-DD date of a newspaper or periodical issue
+	Cookies          []*http.Cookie         // authentication cookies
+	TempDir          string                 // parent of the temporary image folder, default is the working directory
+	OutputName       string                 // base name of the output files, default is the book ID
+	BaseURL          string                 // IIIF image server, default is DefaultBaseURL
+	APIBaseURL       string                 // catalog API serving the IIIF manifests, default is DefaultAPIBaseURL
+	Bandwidth        int64                  // maximum download rate in bytes per second, 0 for no limit
+	Limiter          *Limiter               // rate limit shared with other books downloaded at the same time, replaces Bandwidth
+	Proxy            *url.URL               // HTTP, HTTPS or SOCKS5 proxy, see ParseProxy; default is from the environment
+	RootCAs          *x509.CertPool         // trusted CA certificates, default is the system pool
+	Insecure         bool                   // skip TLS certificate verification, for development only
+	CacheDir         string                 // folder to cache page images in, empty for no cache
+	CoverTemplate    string                 // HTML template rendered with headless Chrome as the first page, see CoverData
+	HeaderTOC        bool                   // bookmark the chapters found from running page headers in the PDF, needs tesseract
+	InferPageNumbers bool                   // read the printed page numbers from the bottom margin for the report and bookmarks, needs tesseract
+	OCRText          bool                   // save the text of the pages as <bookID>_paragraphs.txt, needs tesseract
+	NoSidecar        bool                   // don't describe the download in <bookID>.json, see Sidecar
+	RetryFailed      bool                   // download only the pages that failed or were missed in the last run
+	FailOnDuplicates bool                   // abort when more than 5 consecutive pages are the same image, see PlaceholderError
+	NER              bool                   // save the people, places and organizations named in the text as <bookID>_entities.json, needs tesseract and spaCy
+	NERModel         string                 // spaCy model for NER, default is DefaultNERModel
+	ImageWidth       int                    // page width in pixels, default is DefaultImageWidth
+	PageNrWidth      int                    // digits of numbered pages in image URLs, 0 to detect it (usually DefaultPageNrWidth)
+	ImageFormat      string                 // "jpg" (default) or "png" to request the pages losslessly
+	CropMargin       CropMargin             // border of the full-size scans the IIIF server cuts off, see ParseCropMargin
+	Format           string                 // "pdf" (default), "epub", "images" or "none" to leave the pages in the temporary folder
+	AssumeYes        bool                   // answer yes to all confirmation prompts
+	Confirm          func(string) bool      // asks a yes/no question; nil answers no unless AssumeYes is set
+	SkipVerify       bool                   // don't check downloaded images for corruption
+	NoCompress       bool                   // store PDF page streams uncompressed
+	CompressLevel    int                    // zlib level 1-9 of PDF page streams, default is 1 (fastest)
+	SplitSize        int                    // maximum pages per PDF part, 0 for a single PDF
+	StartPage        int                    // first numbered page to download, 0 for the first page
+	EndPage          int                    // last numbered page to download, 0 for the last page
+	NoCovers         bool                   // skip cover and introduction pages
+	ColorSpace       string                 // "rgb" (default), "gray" or "cmyk" for print, which only applies to PDFs
+	JSONProgress     bool                   // report progress as one JSON object per page
+	StripEXIF        bool                   // re-encode pages without EXIF metadata
+	SpineShadow      bool                   // brighten the shadow along the spine edge of the pages
+	WhiteBackground  bool                   // shift yellowed or off-white paper to white
+	Denoise          bool                   // apply a median filter to reduce scanner noise
+	DenoiseRadius    int                    // median filter radius in pixels, default 1
+	Sharpen          bool                   // apply an unsharp mask to counteract soft scans
+	SharpenAmount    float64                // strength of the unsharp mask, default 1
+	SharpenRadius    float64                // blur radius of the unsharp mask in pixels, default 1
+	SharpenThreshold float64                // minimum difference to sharpen on the 0-255 scale
+	Binarize         bool                   // convert the pages to black and white with an adaptive threshold
+	BinarizeWindow   int                    // window size of the adaptive threshold in pixels, default 25
+	BinarizeK        float64                // Sauvola k parameter, default 0.2
+	AutoMargin       bool                   // crop the pages to their text area and add consistent margins
+	Margin           float64                // margin around the text area in mm, default 10
+	Padding          int                    // border added around the pages in pixels
+	PaddingColor     color.Color            // color of the border, default white
+	OnStart          func(pageIDs []string) // called with the pages to download before the first one
+	OnPage           func(PageEvent)        // called after each page instead of displaying progress
+	WriteReport      bool                   // save the download summary as <bookID>_report.json
+	Log              io.Writer              // receives progress and log messages, nil discards them
+}
+
+// documentTypes lists the supported nb.no document types
+var documentTypes = []string{"digibok", "pliktmonografi", "avis", "tidsskrift"}
+
+// DocumentTypes returns the supported nb.no document types
+func DocumentTypes() []string {
+	return slices.Clone(documentTypes)
+}
+
+// ValidateDocumentType checks that docType is a supported document type
+func ValidateDocumentType(docType string) error {
+	for _, t := range documentTypes {
+		if docType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown document type %q, expected one of: %s", docType, strings.Join(documentTypes, ", "))
+}
+
+// ValidateIssueDate checks the issue date for the document type.
+// Newspapers are identified by date, so avis requires one.
+func ValidateIssueDate(docType, issueDate string) error {
+	if issueDate == "" {
+		if docType == "avis" {
+			return fmt.Errorf("avis documents require an issue date in YYYY-MM-DD format")
+		}
+		return nil
+	}
+	if _, err := time.Parse("2006-01-02", issueDate); err != nil {
+		return fmt.Errorf("invalid issue date %q, expected YYYY-MM-DD", issueDate)
+	}
+	return nil
+}
+
+// bookIDFormat is the pattern of the book IDs of a document type and its
+// description for error messages
+type bookIDFormat struct {
+	re   *regexp.Regexp
+	desc string
+}
+
+// titleIDFormat matches the title IDs of newspapers, periodicals and audio
+// recordings
+var titleIDFormat = bookIDFormat{regexp.MustCompile(`^[a-z0-9]+$`), "lowercase letters and digits only, e.g. aftenposten"}
+
+// bookIDFormats maps each document type to the format of its book IDs
+var bookIDFormats = map[string]bookIDFormat{
+	"digibok":         {regexp.MustCompile(`^\d{13}$`), "13 digits, e.g. 2008011100001"},
+	"pliktmonografi":  {regexp.MustCompile(`^\d{9}$`), "9 digits, e.g. 000040863"},
+	"avis":            titleIDFormat,
+	"tidsskrift":      titleIDFormat,
+	AudioDocumentType: titleIDFormat,
+}
+
+// ValidateBookID checks that id has the format of the book IDs of docType,
+// so that a mistyped ID, such as a URL fragment or a Dewey number, is
+// rejected before any request is made
+func ValidateBookID(id, docType string) error {
+	format, ok := bookIDFormats[docType]
+	if !ok {
+		return ValidateDocumentType(docType)
+	}
+	if id == "" {
+		return fmt.Errorf("no book ID given, %s IDs are %s", docType, format.desc)
+	}
+	if !format.re.MatchString(id) {
+		return fmt.Errorf("invalid %s ID %q, expected %s", docType, id, format.desc)
+	}
+	return nil
+}
+
+// DefaultImageWidth is the page width in pixels requested unless another is given
+const DefaultImageWidth = 602
+
+// DefaultPageNrWidth is the number of digits numbered pages are padded to in
+// image URLs. Some collections use 5 or 6 digits instead.
+const DefaultPageNrWidth = 4
+
+// DefaultBaseURL is the nb.no IIIF image server
+const DefaultBaseURL = "https://www.nb.no/services/image/resolver"
+
+// DefaultAPIBaseURL is the nb.no catalog API, which serves the IIIF
+// manifests and the catalog records of audio recordings
+const DefaultAPIBaseURL = "https://api.nb.no/catalog/v1"
+
+// NewBook creates a new Book instance. Page images are stored in a folder
+// below opts.TempDir, or below the working directory when it is empty.
+// Images are requested from the IIIF server at opts.BaseURL and manifests
+// from the catalog API at opts.APIBaseURL, or from nb.no when they are
+// empty.
+func NewBook(bookID string, opts DownloadOptions) *Book {
+	// Default to digibok if not specified
+	docType := opts.DocumentType
+	if docType == "" {
+		docType = "digibok"
+	}
+
+	client := newHTTPClient(opts)
+	limiter := opts.limiter()
+
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	// The IIIF server picks the image format from the extension
+	imageFormat := cmp.Or(opts.ImageFormat, "jpg")
+
+	// Direct image URL template based on browser requests
+	urlTemplate := "{base_url}/URN:NBN:no-nb_{docType}_{book_id}_{long_page_nr}/full/602,/0/default.{format}"
+	urlTemplate = strings.Replace(urlTemplate, "{base_url}", baseURL, 1)
+	urlTemplate = strings.Replace(urlTemplate, "{docType}", docType, 1)
+	urlTemplate = strings.Replace(urlTemplate, "{format}", imageFormat, 1)
+
+	format := opts.Format
+	if format == "" {
+		format = "pdf"
+	}
+	colorSpace := opts.ColorSpace
+	if colorSpace == "" {
+		colorSpace = "rgb"
+	}
+	log := opts.Log
+	if log == nil {
+		log = io.Discard
+	}
+	// Downloads to different names, e.g. of one ID as several document
+	// types, get separate temporary folders
+	name := cmp.Or(opts.OutputName, bookID)
+
+	b := &Book{
+		mu:               new(sync.RWMutex),
+		id:               bookID,
+		name:             name,
+		length:           opts.Length,
+		path:             name + "_temp_image_folder",
+		baseURL:          baseURL,
+		apiBaseURL:       opts.apiBaseURL(),
+		urlTemplate:      urlTemplate,
+		client:           client,
+		bandwidth:        limiter.bandwidth,
+		limiter:          limiter,
+		cache:            newPageCache(opts.CacheDir),
+		coverTemplate:    opts.CoverTemplate,
+		headerTOC:        opts.HeaderTOC,
+		inferPageNumbers: opts.InferPageNumbers,
+		ocrText:          opts.OCRText,
+		sidecar:          !opts.NoSidecar,
+		retryFailed:      opts.RetryFailed,
+		failOnDuplicates: opts.FailOnDuplicates,
+		ner:              opts.NER,
+		nerModel:         cmp.Or(opts.NERModel, DefaultNERModel),
+		pageNrWidth:      opts.PageNrWidth,
+		documentType:     docType,
+		issueDate:        opts.IssueDate,
+		format:           format,
+		imageWidth:       DefaultImageWidth,
+		imageFormat:      imageFormat,
+		cropMargin:       opts.CropMargin,
+		assumeYes:        opts.AssumeYes,
+		confirm:          opts.Confirm,
+		skipVerify:       opts.SkipVerify,
+		compressLevel:    opts.compressLevel(),
+		splitSize:        opts.SplitSize,
+		startPage:        opts.StartPage,
+		endPage:          opts.EndPage,
+		noCovers:         opts.NoCovers,
+		colorSpace:       colorSpace,
+		jsonProgress:     opts.JSONProgress,
+		stripEXIF:        opts.StripEXIF,
+		spineShadow:      opts.SpineShadow,
+		whiteBackground:  opts.WhiteBackground,
+		denoise:          opts.Denoise,
+		denoiseRadius:    cmp.Or(opts.DenoiseRadius, 1),
+		sharpen:          opts.Sharpen,
+		sharpenAmount:    cmp.Or(opts.SharpenAmount, 1),
+		sharpenRadius:    cmp.Or(opts.SharpenRadius, 1),
+		sharpenThreshold: opts.SharpenThreshold,
+		binarize:         opts.Binarize,
+		binarizeWindow:   cmp.Or(opts.BinarizeWindow, 25),
+		binarizeK:        cmp.Or(opts.BinarizeK, 0.2),
+		autoMargin:       opts.AutoMargin,
+		margin:           cmp.Or(opts.Margin, 10),
+		padding:          opts.Padding,
+		paddingColor:     cmp.Or[color.Color](opts.PaddingColor, color.White),
+		onStart:          opts.OnStart,
+		onPage:           opts.OnPage,
+		writeReport:      opts.WriteReport,
+		log:              log,
+	}
+
+	// Set authentication cookies if provided, and watch for the server
+	// replacing them
+	if len(opts.Cookies) > 0 {
+		cookieURL, _ := url.Parse(baseURL)
+		b.client.Jar.SetCookies(cookieURL, opts.Cookies)
+		b.cookies = &sessionCookies{url: cookieURL, given: opts.Cookies, log: log}
+		b.client.Jar = &CookieChangeListener{CookieJar: b.client.Jar, OnChange: b.cookies.changed}
+	}
+
+	if opts.ImageWidth > 0 && opts.ImageWidth != DefaultImageWidth {
+		b.setImageWidth(opts.ImageWidth)
+	}
+
+	b.fullpath = filepath.Join(opts.TempDir, b.path)
+
+	return b
+}
+
+// compressLevel returns the zlib level of PDF page streams, 0 with NoCompress
+func (opts DownloadOptions) compressLevel() int {
+	if opts.NoCompress {
+		return 0
+	}
+	return cmp.Or(opts.CompressLevel, zlib.BestSpeed)
+}
+
+// apiBaseURL returns the catalog API to use, without a trailing slash
+func (opts DownloadOptions) apiBaseURL() string {
+	return strings.TrimSuffix(cmp.Or(opts.APIBaseURL, DefaultAPIBaseURL), "/")
+}
+
+// Clone returns a copy of the book for downloading newID with the same
+// options. The copy shares the HTTP client, and with it the cookies, as
+// well as the rate limits and the page cache, but nothing that was found
+// out about b: the length, the page number width, the metadata and the
+// results of the last download are reset. b and its clones can download at
+// the same time.
+func (b *Book) Clone(newID string) *Book {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	c := *b
+	c.mu = new(sync.RWMutex)
+	c.id = newID
+	c.name = newID
+	c.length = 0
+	c.path = newID + "_temp_image_folder"
+	c.fullpath = filepath.Join(filepath.Dir(b.fullpath), c.path)
+	c.metadata, c.manifest, c.manifestErr = nil, nil, nil
+	c.pageNrWidth = 0
+	c.last, c.report = nil, nil
+	return &c
+}
+
+// ID returns the nb.no identifier of the book
+func (b *Book) ID() string {
+	return b.id
+}
+
+// DocumentType returns the nb.no document type of the book
+func (b *Book) DocumentType() string {
+	return b.documentType
+}
+
+// ImageFormat returns the format the page images are requested and saved
+// in, "jpg" or "png"
+func (b *Book) ImageFormat() string {
+	return b.imageFormat
+}
+
+// Length returns the number of numbered pages, or 0 if it is not known yet
+func (b *Book) Length() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.length
+}
+
+// setLength records the length of the book once it is found
+func (b *Book) setLength(length int) {
+	b.mu.Lock()
+	b.length = length
+	b.mu.Unlock()
+}
+
+// OutputPath returns the file or folder written by the last successful
+// Download. For split PDFs it is the first part.
+func (b *Book) OutputPath() string {
+	outPath, _, _ := b.lastResult()
+	return outPath
+}
+
+// TargetPath returns the file or folder that Download will write, e.g. to
+// check for an existing file before downloading. For split PDFs it is the
+// first part.
+func (b *Book) TargetPath() string {
+	switch {
+	case b.format == "images":
+		return b.name + "_pages"
+	case b.format == "none":
+		return b.fullpath
+	case b.format == "epub":
+		return b.name + ".epub"
+	case b.splitSize > 0:
+		return splitPartPath(b.name, 1)
+	}
+	return b.name + ".pdf"
+}
+
+// splitPartPath returns the file name of part n of a split PDF
+func splitPartPath(name string, n int) string {
+	return fmt.Sprintf("%s_part%02d.pdf", name, n)
+}
+
+// PageCount returns the number of pages in the output of the last Download
+func (b *Book) PageCount() int {
+	_, pageCount, _ := b.lastResult()
+	return pageCount
+}
+
+// CoverPath returns the front cover image saved by the last successful
+// Download, or "" if there is none, e.g. because of NoCovers
+func (b *Book) CoverPath() string {
+	outPath := b.OutputPath()
+	if outPath == "" {
+		return ""
+	}
+	cover := b.pagePath("C1")
+	if b.format == "images" {
+		// saveImages numbers the pages, so the cover is the first file
+		// unless it is preceded by the cover template
+		nr := 1
+		if b.coverTemplate != "" {
+			nr = 2
+		}
+		cover = filepath.Join(outPath, fmt.Sprintf("%04d_C1.%s", nr, b.imageFormat))
+	}
+	if _, err := os.Stat(cover); err != nil {
+		return ""
+	}
+	return cover
+}
+
+// PageErrors returns the errors of the pages the last Download had to skip
+func (b *Book) PageErrors() []error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.last == nil {
+		return nil
+	}
+	return b.last.pageErrors
+}
+
+// Stats returns the page and byte counts of the running or last Download.
+// It may be called from another goroutine while the book downloads.
+func (b *Book) Stats() DownloadStats {
+	b.mu.RLock()
+	var p *progress
+	if b.last != nil {
+		p = b.last.progress
+	}
+	b.mu.RUnlock()
+	return p.snapshot()
+}
+
+// setProgress replaces the progress display of the download
+func (d *download) setProgress(p *progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.progress = p
+}
+
+// Report returns the summary of the last Download, or nil before the first
+func (b *Book) Report() *Report {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.report
+}
+
+// ensureTempDir creates the temporary image folder if it does not exist yet
+func (b *Book) ensureTempDir() {
+	if _, err := os.Stat(b.fullpath); os.IsNotExist(err) {
+		os.MkdirAll(b.fullpath, 0755)
+	}
+}
+
+// pagePath returns the path of a page image in the temporary image folder
+func (b *Book) pagePath(pageID string) string {
+	return filepath.Join(b.fullpath, pageID+"."+b.imageFormat)
+}
+
+// pageIDOf returns the page ID of a page image, e.g. C1 for
+// 2008011100001_temp_image_folder/C1.jpg
+func pageIDOf(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// setImageWidth changes the requested image width in the URL template
+func (b *Book) setImageWidth(width int) {
+	b.urlTemplate = strings.Replace(b.urlTemplate, fmt.Sprintf("/%d,/", b.imageWidth), fmt.Sprintf("/%d,/", width), 1)
+	b.imageWidth = width
+}
+
+// get sends a request for url that is cancelled together with ctx
+func (b *Book) get(ctx context.Context, method, url string) (*http.Response, error) {
+	return b.send(ctx, b.client, method, url)
+}
+
+// get sends a request for url with the client of the download, which has
+// no cookies for a public book
+func (d *download) get(ctx context.Context, method, url string) (*http.Response, error) {
+	return d.send(ctx, d.client, method, url)
+}
+
+// send sends a request for url with client once the rate limits allow it.
+// The request is cancelled together with ctx.
+func (b *Book) send(ctx context.Context, client *http.Client, method, url string) (*http.Response, error) {
+	if err := b.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// sleep waits for d or until ctx is done, whichever comes first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pageLayout lists the unnumbered pages before and after the numbered pages
+// of a book
+type pageLayout struct {
+	front []string // covers and introduction pages, e.g. C1, I1, I2
+	back  []string // back covers, e.g. C3
+}
+
+// isCoverPage reports whether pageID is a cover, e.g. C1 or C3, or the
+// rendered cover template
+func isCoverPage(pageID string) bool {
+	return strings.HasPrefix(pageID, "C") || pageID == coverTemplatePage
+}
+
+// findPageLayout returns the cover and introduction pages listed in the
+// IIIF manifest. Without a manifest the front cover C1, the introduction
+// pages found by probing and the back cover C3 are assumed.
+func (b *Book) findPageLayout(ctx context.Context) pageLayout {
+	if manifest, err := b.fetchManifest(ctx); err == nil && manifest.Length() > 0 {
+		return manifest.layout()
+	}
+	layout := pageLayout{front: []string{"C1"}, back: []string{"C3"}}
+	for i := 1; i <= b.countIntroPages(ctx); i++ {
+		layout.front = append(layout.front, fmt.Sprintf("I%d", i))
+	}
+	return layout
+}
+
+// countIntroPages probes for introduction pages (I1, I2, etc.) and returns
+// how many exist. Probing stops early if ctx is cancelled.
+func (b *Book) countIntroPages(ctx context.Context) int {
+	count := 0
+	for {
+		resp, err := b.get(ctx, http.MethodHead, b.PageURL(fmt.Sprintf("I%d", count+1)))
+		if err != nil {
+			return count
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return count
+		}
+		count++
+	}
+}
+
+// detectPageNrWidth finds the number of digits numbered pages are padded
+// to by requesting page 1 with 4, 5 and 6 digits, at most 3 requests. It
+// returns the first width that is found, or DefaultPageNrWidth if the
+// 4-digit URL is not answered with 404 Not Found or no width works.
+func (b *Book) detectPageNrWidth(ctx context.Context) int {
+	for width := DefaultPageNrWidth; width <= DefaultPageNrWidth+2; width++ {
+		resp, err := b.get(ctx, http.MethodHead, b.pageURL("1", width))
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return width
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			break
+		}
+	}
+	return DefaultPageNrWidth
+}
+
+// resolvePageNrWidth detects the page number width on first use unless it
+// was given in the options
+func (b *Book) resolvePageNrWidth(ctx context.Context) {
+	if b.PageNrWidth() != 0 {
+		return
+	}
+	width := b.detectPageNrWidth(ctx)
+	if width != DefaultPageNrWidth {
+		fmt.Fprintf(b.log, "Page numbers in image URLs have %d digits\n", width)
+	}
+	b.setPageNrWidth(width)
+}
+
+// PageNrWidth returns the number of digits numbered pages are padded to in
+// image URLs, or 0 if it is not known yet
+func (b *Book) PageNrWidth() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pageNrWidth
+}
+
+// setPageNrWidth records the page number width once it is found
+func (b *Book) setPageNrWidth(width int) {
+	b.mu.Lock()
+	b.pageNrWidth = width
+	b.mu.Unlock()
+}
+
+// pageRetries is how often a page is requested again after a network
+// error or a corrupt image
+const pageRetries = 3
+
+// rateLimitRetries is how often a page is requested again in a row after
+// HTTP 429 Too Many Requests. Waiting out a rate limit doesn't use up one of
+// the pageRetries, but a server that never lets up must not keep the
+// download waiting forever.
+const rateLimitRetries = 10
+
+// pageContext is the state of downloading one page. Every page gets its
+// own, so that pages can be downloaded from several goroutines.
+type pageContext struct {
+	pageNr string
+	params map[string]string // values of the URL template placeholders
+	url    string
+	retry  int    // retries left
+	data   []byte // image as downloaded, nil if it was taken from the cache
+	log    io.Writer
+}
+
+// newPageContext prepares the download of a page
+func (d *download) newPageContext(pageNr string) *pageContext {
+	params := d.pageParams(pageNr, d.PageNrWidth())
+	return &pageContext{
+		pageNr: pageNr,
+		params: params,
+		url:    d.formatURL(params),
+		retry:  pageRetries,
+		log:    d.log,
+	}
+}
+
+// PageURL returns the image URL for a single page
+func (b *Book) PageURL(pageNr string) string {
+	return b.pageURL(pageNr, b.PageNrWidth())
+}
+
+// pageURL returns the image URL for a page with numbered pages padded to
+// width digits, DefaultPageNrWidth if width is 0
+func (b *Book) pageURL(pageNr string, width int) string {
+	return b.formatURL(b.pageParams(pageNr, width))
+}
+
+// formatURL replaces template placeholders with actual values
+func (b *Book) formatURL(params map[string]string) string {
+	return fillURLTemplate(b.urlTemplate, params)
+}
+
+// formatURL fills in the download's URL template, which requests the crop
+// region instead of the full page once it is resolved
+func (d *download) formatURL(params map[string]string) string {
+	return fillURLTemplate(d.urlTemplate, params)
+}
+
+// fillURLTemplate replaces the placeholders in a URL template with params
+func fillURLTemplate(url string, params map[string]string) string {
+	for key, value := range params {
+		url = strings.Replace(url, "{"+key+"}", value, -1)
+	}
+	return url
+}
+
+// downloadPage downloads a single page directly. Network errors and corrupt
+// images are retried; the returned error is one of *AuthError,
+// *NetworkError, *PageNotFoundError or *StorageError. Cancelling ctx aborts
+// the request and stops further retries.
+func (d *download) downloadPage(ctx context.Context, pc *pageContext) error {
+	pageNr, url := pc.pageNr, pc.url
+	outPath := d.pagePath(pageNr)
+
+	if d.cache.link(url, outPath) {
+		if d.progress.verbose() {
+			fmt.Fprintf(pc.log, "Page %s found in cache\n", pageNr)
+		}
+		return d.finishPage(ctx, pc, outPath)
+	}
+
+	if d.progress.verbose() {
+		fmt.Fprintf(pc.log, "Downloading page %s: %s\n", pageNr, url)
+	}
+
+	var resp *http.Response
+	for rateLimited := 0; ; rateLimited++ {
+		var err error
+		resp, err = d.get(ctx, http.MethodGet, url)
+		if err != nil {
+			d.progress.interrupt()
+			fmt.Fprintln(pc.log, "Download Error:", err)
+			fmt.Fprintln(pc.log, "Tried to access "+url)
+			return d.retryPage(ctx, pc, &NetworkError{Page: pageNr, URL: url, Err: err})
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || rateLimited == rateLimitRetries {
+			break
+		}
+
+		// Being rate limited is not the page's fault, so wait as asked and
+		// try again without using up a retry. Books sharing the limiter
+		// wait too.
+		resp.Body.Close()
+		d.progress.interrupt()
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		fmt.Fprintf(pc.log, "Rate limited by server, waiting %s before retrying\n", wait.Round(time.Millisecond))
+		d.limiter.pause(wait)
+		if err := d.limiter.wait(ctx); err != nil {
+			return &NetworkError{Page: pageNr, URL: url, Err: err}
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		d.progress.interrupt()
+		fmt.Fprintf(pc.log, "Download Error: HTTP Status %d\n", resp.StatusCode)
+		fmt.Fprintln(pc.log, "Tried to access "+url)
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			fmt.Fprintln(pc.log, "Authentication failed - check your cookies.")
+			fmt.Fprintln(pc.log, "Try using -cookie-file or -cookies with valid authentication.")
+			dumpCookies(pc.log, d.client, d.baseURL)
+			return &AuthError{Page: pageNr, StatusCode: resp.StatusCode}
+		case http.StatusNotFound:
+			return &PageNotFoundError{Page: pageNr, URL: url}
+		case http.StatusTooManyRequests:
+			fmt.Fprintf(pc.log, "Still rate limited after %d retries, giving up\n", rateLimitRetries)
+			return &NetworkError{Page: pageNr, URL: url, StatusCode: resp.StatusCode}
+		}
+		return d.retryPage(ctx, pc, &NetworkError{Page: pageNr, URL: url, StatusCode: resp.StatusCode})
+	}
+
+	// Download successful, save the image
+	imgData, err := io.ReadAll(&countingReader{r: throttle(ctx, resp.Body, d.bandwidth), progress: d.progress})
+	resp.Body.Close()
+	if err != nil {
+		d.progress.interrupt()
+		fmt.Fprintln(pc.log, "Error reading response:", err)
+		return d.retryPage(ctx, pc, &NetworkError{Page: pageNr, URL: url, Err: err})
+	}
+
+	// Save the image directly
+	if err := os.WriteFile(outPath, imgData, 0644); err != nil {
+		d.progress.interrupt()
+		fmt.Fprintln(pc.log, "Error writing image file:", err)
+		return &StorageError{Path: outPath, Err: err}
+	}
+
+	// The image is cached by Download once it is known not to be a
+	// placeholder
+	pc.data = imgData
+	return d.finishPage(ctx, pc, outPath)
+}
+
+// finishPage verifies and processes a page saved at outPath, either
+// downloaded or taken from the cache
+func (d *download) finishPage(ctx context.Context, pc *pageContext, outPath string) error {
+	// A truncated image is treated like a failed request
+	if !d.skipVerify {
+		if err := verifyImage(outPath); err != nil {
+			d.cache.remove(pc.url)
+			d.progress.interrupt()
+			fmt.Fprintf(pc.log, "Page %s is corrupt: %v\n", pc.pageNr, err)
+			return d.retryPage(ctx, pc, &NetworkError{Page: pc.pageNr, URL: pc.url, Err: err})
+		}
+	}
+
+	if err := d.processImage(outPath, pc.pageNr); err != nil {
+		d.progress.interrupt()
+		fmt.Fprintln(pc.log, "Error processing image:", err)
+		return &StorageError{Path: outPath, Err: err}
+	}
+
+	if d.progress.verbose() {
+		fmt.Fprintf(pc.log, "Page %s downloaded successfully\n", pc.pageNr)
+	}
+	return nil
+}
+
+// retryPage downloads a page again if there are retries left, otherwise it
+// returns the error of the last attempt. Nothing is retried once ctx is
+// cancelled.
+func (d *download) retryPage(ctx context.Context, pc *pageContext, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	if pc.retry > 0 {
+		pc.retry--
+		d.progress.interrupt()
+		fmt.Fprintf(pc.log, "Retrying.... %d tries remaining.\n", pc.retry)
+		return d.downloadPage(ctx, pc) // Recursively retry
+	}
+	d.progress.interrupt()
+	fmt.Fprintln(pc.log, "All retries failed")
+	return err
+}
+
+// defaultRetryAfter is the wait after HTTP 429 without a usable Retry-After header
+const defaultRetryAfter = 5 * time.Second
+
+// retryAfter parses a Retry-After header, which is either a number of seconds
+// or an HTTP date, and adds up to a second of jitter so that several clients
+// don't retry in lockstep
+func retryAfter(header string, now time.Time) time.Duration {
+	wait := defaultRetryAfter
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = max(date.Sub(now), 0)
+	}
+	return wait + rand.N(time.Second)
+}
+
+// verifyImage checks that the file at path decodes as a complete JPEG or
+// PNG image
+func verifyImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("invalid image header: %w", err)
+	}
+
+	// DecodeConfig only reads the header, so decode the whole image to
+	// catch truncated scan data
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, _, err := image.Decode(f); err != nil {
+		return fmt.Errorf("invalid %s data: %w", strings.ToUpper(format), err)
+	}
+	return nil
+}
+
+// dumpCookies writes the current cookies in the client jar to w (for debugging)
+func dumpCookies(w io.Writer, client *http.Client, urlStr string) {
+	if client.Jar == nil {
+		fmt.Fprintln(w, "No cookie jar available")
+		return
+	}
+
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		fmt.Fprintln(w, "Error parsing URL for cookie dump:", err)
+		return
+	}
+
+	cookies := client.Jar.Cookies(parsedURL)
+	if len(cookies) == 0 {
+		fmt.Fprintln(w, "No cookies found in jar")
+		return
+	}
+
+	fmt.Fprintln(w, "Current cookies in jar:")
+	for _, cookie := range cookies {
+		fmt.Fprintf(w, "  %s = %s\n", cookie.Name, cookie.Value)
+	}
+}
+
+// findBookLength attempts to determine the book's length. It fails only if
+// ctx is cancelled.
+func (b *Book) findBookLength(ctx context.Context) (int, error) {
+	b.resolvePageNrWidth(ctx)
+	delta := 100
+	j := 100
+
+	for {
+		url := b.PageURL(strconv.Itoa(j))
+
+		resp, err := b.get(ctx, http.MethodGet, url)
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		found := err == nil && resp.StatusCode == http.StatusOK
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !found {
+			// Too far
+			if delta == 1 {
+				return j - 1, nil
+			}
+			j -= delta
+			delta = delta / 10
+			if delta < 1 {
+				delta = 1
+			}
+			j += delta
+		} else {
+			j += delta
+		}
+	}
+}
+
+// ProbeLength determines the book length by requesting pages until one is missing
+func (b *Book) ProbeLength(ctx context.Context) (int, error) {
+	return b.findBookLength(ctx)
+}
+
+// LengthFromManifest returns the book length listed in its IIIF manifest
+func (b *Book) LengthFromManifest(ctx context.Context) (int, error) {
+	return b.findBookLengthFromManifest(ctx)
+}
+
+// ResolveLength fills in the book length from the manifest, falling back to
+// probing, unless it is already known
+func (b *Book) ResolveLength(ctx context.Context) error {
+	if b.Length() > 0 {
+		return nil
+	}
+	length, err := b.resolveLength(ctx)
+	if err != nil {
+		return err
+	}
+	b.setLength(length)
+	return nil
+}
+
+// resolveLength does the work of ResolveLength
+func (b *Book) resolveLength(ctx context.Context) (int, error) {
+	length, err := b.findBookLengthFromManifest(ctx)
+	if err == nil && length > 0 {
+		return length, nil
+	}
+	return b.findBookLength(ctx)
+}
+
+// PageIDs lists the page identifiers of the book in reading order. The book
+// length must be known, see ResolveLength.
+func (b *Book) PageIDs(ctx context.Context) []string {
+	return b.pageIDs(b.findPageLayout(ctx))
+}
+
+// CheckAccess checks that the book exists and that the cookies grant access
+// to it without downloading anything. It sends HEAD requests for the front
+// cover and the first page of the selected range. The error is an
+// *AuthError, *PageNotFoundError or *NetworkError.
+func (b *Book) CheckAccess(ctx context.Context) error {
+	b.resolvePageNrWidth(ctx)
+	start, _ := b.pageRange()
+	for _, pageNr := range []string{"C1", strconv.Itoa(start)} {
+		url := b.PageURL(pageNr)
+		resp, err := b.get(ctx, http.MethodHead, url)
+		if err != nil {
+			return &NetworkError{Page: pageNr, URL: url, Err: err}
+		}
+		resp.Body.Close()
+
+		if err := statusError(pageNr, url, resp.StatusCode); err != nil {
+			return err
+		}
+		fmt.Fprintf(b.log, "Page %s: access granted\n", pageNr)
+	}
+	return nil
+}
+
+// IssueAvailable reports whether there is a newspaper or periodical issue
+// on the book's issue date, by sending a HEAD request for its first page.
+// While the page number width is unknown, each width is tried in turn, as
+// in detecting it, and the one found is kept for the download. 404 Not
+// Found means there is no issue; other failures are returned as
+// *AuthError or *NetworkError.
+func (b *Book) IssueAvailable(ctx context.Context) (bool, error) {
+	known := b.PageNrWidth()
+	widths := []int{known}
+	if known == 0 {
+		widths = []int{DefaultPageNrWidth, DefaultPageNrWidth + 1, DefaultPageNrWidth + 2}
+	}
+	for _, width := range widths {
+		url := b.pageURL("1", width)
+		resp, err := b.get(ctx, http.MethodHead, url)
+		if err != nil {
+			return false, &NetworkError{Page: "1", URL: url, Err: err}
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err := statusError("1", url, resp.StatusCode); err != nil {
+			return false, err
+		}
+		if known == 0 {
+			b.setPageNrWidth(width)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// FetchPage downloads the image of a single page at the configured width
+// without retrying, processing or saving it, or takes it from the page
+// cache. A single page cannot be told from a placeholder, so it is not
+// added to the cache. The error is an *AuthError, *PageNotFoundError or
+// *NetworkError.
+func (b *Book) FetchPage(ctx context.Context, pageNr string) ([]byte, error) {
+	b.resolvePageNrWidth(ctx)
+	url := b.PageURL(pageNr)
+	if data, ok := b.cache.read(url); ok {
+		return data, nil
+	}
+	resp, err := b.get(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, &NetworkError{Page: pageNr, URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(pageNr, url, resp.StatusCode); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(throttle(ctx, resp.Body, b.bandwidth))
+	if err != nil {
+		return nil, &NetworkError{Page: pageNr, URL: url, Err: err}
+	}
+	return data, nil
+}
+
+// DownloadPage downloads a single page with the same retries, verification
+// and image filters as Download and saves it as an image file at outPath
+func (b *Book) DownloadPage(ctx context.Context, pageNr, outPath string) error {
+	b.resolvePageNrWidth(ctx)
+	b.ensureTempDir()
+	// The temporary folder is only removed if no earlier download uses it
+	defer os.Remove(b.fullpath)
+
+	d := b.newDownload()
+	if err := d.downloadPage(ctx, d.newPageContext(pageNr)); err != nil {
+		return err
+	}
+	if err := replaceFile(b.pagePath(pageNr), outPath); err != nil {
+		return &StorageError{Path: outPath, Err: err}
+	}
+	return nil
+}
+
+// SavePage downloads a single page like DownloadPage, but leaves it in the
+// temporary image folder where Download saves it, e.g. to check the URL or
+// the cookies for one page. Numbered pages may be given zero-padded, as in
+// 0042. It returns the path of the image.
+func (b *Book) SavePage(ctx context.Context, pageNr string) (string, error) {
+	if n, err := strconv.Atoi(pageNr); err == nil && n > 0 {
+		pageNr = strconv.Itoa(n)
+	}
+	b.resolvePageNrWidth(ctx)
+	b.ensureTempDir()
+	d := b.newDownload()
+	if err := d.downloadPage(ctx, d.newPageContext(pageNr)); err != nil {
+		return "", err
+	}
+	return b.pagePath(pageNr), nil
+}
+
+// statusError converts an unsuccessful HTTP status for a page into an
+// *AuthError, *PageNotFoundError or *NetworkError. It returns nil for 200 OK.
+func statusError(pageNr, url string, status int) error {
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &AuthError{Page: pageNr, StatusCode: status}
+	case http.StatusNotFound:
+		return &PageNotFoundError{Page: pageNr, URL: url}
+	}
+	return &NetworkError{Page: pageNr, URL: url, StatusCode: status}
+}
+
+// Download downloads all pages and saves them in the selected format. Pages
+// that cannot be downloaded are skipped and reported by PageErrors; an
+// authentication or storage failure aborts the download. The context is
+// checked between pages. Once pages have been downloaded, a summary is
+// written to the log, see Report.
+func (b *Book) Download(ctx context.Context) error {
+	start := time.Now()
+	d := b.newDownload()
+	b.mu.Lock()
+	b.last = d
+	b.mu.Unlock()
+	err := d.run(ctx)
+
+	report := d.newReport(start, err)
+	b.mu.Lock()
+	b.report = report
+	b.mu.Unlock()
+	if report.PagesAttempted > 0 {
+		report.print(b.log)
+		if b.writeReport {
+			if err := report.save(b.name + "_report.json"); err != nil {
+				fmt.Fprintln(b.log, "Error writing report:", err)
+			}
+		}
+	}
+	return err
+}
+
+// run does the work of Download
+func (d *download) run(ctx context.Context) error {
+	d.ensureTempDir()
+	state := &downloadState{BookID: d.id, Type: d.documentType, Pages: make(map[string]string)}
+	if d.retryFailed {
+		prev, err := d.loadState()
+		if err != nil {
+			return err
+		}
+		state = prev
+	}
+	d.resolvePageNrWidth(ctx)
+	if err := d.checkAuth(ctx); err != nil {
+		return err
+	}
+
+	if d.length == 0 {
+		fmt.Fprintln(d.log, "Length not specified, calculating book length")
+		length, err := d.resolveLength(ctx)
+		if err != nil {
+			return err
+		}
+		d.setLength(length)
+		fmt.Fprintln(d.log, "Book length found:", length)
+	}
+
+	if err := d.validatePageRange(); err != nil {
+		return fmt.Errorf("invalid page range: %w", err)
+	}
+
+	if !d.cropMargin.IsZero() {
+		if err := d.resolveCropRegion(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !d.checkDiskSpace() {
+		return ErrCancelled
+	}
+
+	// The cover template is rendered first, so that a broken template or a
+	// missing browser is reported before the pages are downloaded
+	var coverPage string
+	if d.coverTemplate != "" {
+		path, err := d.renderCoverTemplate(ctx)
+		if err != nil {
+			return fmt.Errorf("error rendering cover template: %w", err)
+		}
+		coverPage = path
+	}
+	var tesseract string
+	if d.headerTOC && d.format == "pdf" || d.inferPageNumbers || d.ocrText || d.ner {
+		path, err := findTesseract()
+		if err != nil {
+			return err
+		}
+		tesseract = path
+	}
+	var python string
+	if d.ner {
+		path, err := d.checkSpacy(ctx)
+		if err != nil {
+			return err
+		}
+		python = path
+	}
+
+	fmt.Fprintf(d.log, "Downloading book %s (type: %s)\n", d.id, d.documentType)
+
+	// Front cover, introduction pages (I1, I2, etc.), numbered pages and back cover
+	layout := d.findPageLayout(ctx)
+	pageIDs := d.pageIDs(layout)
+	if d.retryFailed {
+		retry := d.pagesToRetry(state, pageIDs)
+		d.skipped = len(pageIDs) - len(retry)
+		fmt.Fprintf(d.log, "Retrying %d failed or missing pages, %d are already downloaded\n", len(retry), d.skipped)
+		pageIDs = retry
+	}
+
+	// The state is kept until the download completes
+	complete := false
+	defer func() {
+		if complete {
+			os.Remove(d.statePath())
+		} else if err := d.saveState(state); err != nil {
+			fmt.Fprintln(d.log, "Error saving download state:", err)
+		}
+	}()
+
+	if d.onStart != nil {
+		d.onStart(pageIDs)
+	}
+	d.setProgress(newProgress(len(pageIDs), d.jsonProgress, d.log, d.onPage))
+	if d.sharpen && d.progress.verbose() {
+		fmt.Fprintf(d.log, "Sharpening pages: amount %g, radius %gpx, threshold %g\n",
+			d.sharpenAmount, d.sharpenRadius, d.sharpenThreshold)
+	}
+	var duplicates consecutiveDuplicateDetector
+	var cached []string // URLs this download added to the page cache
+	for _, pageID := range pageIDs {
+		if err := ctx.Err(); err != nil {
+			d.progress.finish()
+			return err
+		}
+		pc := d.newPageContext(pageID)
+		err := d.downloadPage(ctx, pc)
+		if ctx.Err() != nil {
+			// The page was interrupted, not failed
+			d.progress.finish()
+			return ctx.Err()
+		}
+		d.attempted++
+		d.progress.pageDone(pageID, err)
+		if err == nil {
+			state.Pages[pageID] = pageDone
+			err := d.checkPlaceholder(&duplicates, pageID)
+			if pc.data != nil && len(duplicates.pages) <= placeholderRun {
+				// The cache is only an optimisation, so failing to update
+				// it is not an error
+				if err := d.cache.store(pc.url, pc.data); err != nil {
+					fmt.Fprintln(d.log, "Error caching page:", err)
+				} else {
+					cached = append(cached, pc.url)
+				}
+			}
+			if err != nil {
+				// The placeholders are downloaded again by -retry-failed
+				for _, p := range duplicates.pages {
+					state.Pages[p] = pageFailed
+				}
+				d.progress.finish()
+				return fmt.Errorf("aborting download: %w", err)
+			}
+			continue
+		}
+		duplicates.reset()
+		state.Pages[pageID] = pageFailed
+		if isFatal(err) {
+			// Pages of a session the server stopped accepting may be
+			// placeholders too short a run to detect
+			var authErr *AuthError
+			if errors.As(err, &authErr) {
+				for _, url := range cached {
+					d.cache.remove(url)
+				}
+			}
+			d.progress.finish()
+			return fmt.Errorf("aborting download: %w", err)
+		}
+		d.mu.Lock()
+		d.pageErrors = append(d.pageErrors, err)
+		d.mu.Unlock()
+	}
+	d.progress.finish()
+
+	if len(d.pageErrors) > 0 {
+		fmt.Fprintf(d.log, "%d pages could not be downloaded:\n", len(d.pageErrors))
+		for _, err := range d.pageErrors {
+			fmt.Fprintln(d.log, "  "+err.Error())
+		}
+	}
+
+	pages := d.collectPages(layout)
+	if coverPage != "" {
+		pages = append([]string{coverPage}, pages...)
+	}
+
+	// Missing text, page numbers or bookmarks are not worth losing the
+	// download for
+	var textPages, texts []string
+	if d.ocrText || d.ner {
+		var err error
+		if textPages, texts, err = d.ocrPages(ctx, tesseract, pages); err != nil {
+			fmt.Fprintln(d.log, "Skipping text:", err)
+		}
+	}
+	if d.ocrText && texts != nil {
+		path, err := d.saveParagraphs(texts)
+		if err != nil {
+			fmt.Fprintln(d.log, "Skipping text:", err)
+		} else {
+			fmt.Fprintln(d.log, "Text saved to", path)
+		}
+	}
+	if d.inferPageNumbers {
+		numbers, err := d.readPageNumbers(ctx, tesseract, pages)
+		if err != nil {
+			fmt.Fprintln(d.log, "Skipping page numbers:", err)
+		}
+		d.pageNumbers = numbers
+	}
+	if d.ner && texts != nil {
+		path, err := d.saveEntities(ctx, python, textPages, texts)
+		if err != nil {
+			fmt.Fprintln(d.log, "Skipping named entities:", err)
+		} else {
+			fmt.Fprintln(d.log, "Named entities saved to", path)
+		}
+	}
+	if d.headerTOC && d.format == "pdf" {
+		chapters, err := d.detectChapters(ctx, tesseract, pages)
+		if err != nil {
+			fmt.Fprintln(d.log, "Skipping table of contents:", err)
+		}
+		d.bookmarks = make(map[string]string)
+		for _, c := range chapters {
+			title := c.title
+			pageID := pageIDOf(pages[c.start])
+			if nr, ok := d.pageNumbers[pageID]; ok {
+				title += ", p. " + nr
+			}
+			d.bookmarks[pages[c.start]] = title
+		}
+	}
+
+	var outPath string
+	var err error
+	switch d.format {
+	case "images":
+		outPath, err = d.saveImages(pages)
+	case "epub":
+		outPath, err = d.saveEPUB(ctx, pages)
+	case "none":
+		// The pages are assembled by the caller, e.g. with MergePDF
+		outPath = d.fullpath
+		d.outFiles = []string{outPath}
+	default:
+		outPath, err = d.savePDF(ctx, pages)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.outPath, d.pageCount, d.pages = outPath, len(pages), pages
+	d.mu.Unlock()
+	if d.sidecar {
+		if err := d.writeSidecar(pages); err != nil {
+			fmt.Fprintln(d.log, "Error writing sidecar file:", err)
+		}
+	}
+	complete = len(d.pageErrors) == 0
+	return nil
+}
+
+// pageIDs lists the page identifiers of the book in reading order, limited
+// to the selected page range and without covers if noCovers is set
+func (b *Book) pageIDs(layout pageLayout) []string {
+	var ids []string
+	if !b.noCovers {
+		ids = append(ids, layout.front...)
+	}
+
+	start, end := b.pageRange()
+	for page := start; page <= end; page++ {
+		ids = append(ids, strconv.Itoa(page))
+	}
+
+	if !b.noCovers {
+		ids = append(ids, layout.back...)
+	}
+	return ids
+}
+
+// pageRange returns the first and last numbered page to download
+func (b *Book) pageRange() (start, end int) {
+	start, end = 1, b.length
+	if b.startPage > 0 {
+		start = b.startPage
+	}
+	if b.endPage > 0 {
+		end = b.endPage
+	}
+	return start, end
+}
+
+// validatePageRange checks -start-page and -end-page against the book length
+func (b *Book) validatePageRange() error {
+	start, end := b.pageRange()
+	if start > end {
+		return fmt.Errorf("start page %d is after end page %d", start, end)
+	}
+	if end > b.length {
+		return fmt.Errorf("end page %d is beyond the last page of the book (%d)", end, b.length)
+	}
+	return nil
+}
+
+// collectPages returns the downloaded image files in reading order
+func (b *Book) collectPages(layout pageLayout) []string {
+	var pages []string
+	for _, pageID := range b.pageIDs(layout) {
+		imgPath := b.pagePath(pageID)
+		if _, err := os.Stat(imgPath); err == nil {
+			pages = append(pages, imgPath)
+		}
+	}
+	return pages
+}
+
+// replaceFile moves src to dst, replacing dst. Renaming is atomic when both
+// are on the same filesystem. If the rename fails, e.g. because they are on
+// different mounts, src is copied and removed instead.
+func replaceFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst through a temporary file next to dst, which is
+// then renamed on dst's filesystem
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// splitPages divides pages into consecutive groups of at most n pages,
+// keeping the reading order so the front cover ends up in the first group
+// and the back cover in the last
+func splitPages(pages []string, n int) [][]string {
+	if n <= 0 || len(pages) <= n {
+		return [][]string{pages}
+	}
+
+	var parts [][]string
+	for start := 0; start < len(pages); start += n {
+		parts = append(parts, pages[start:min(start+n, len(pages))])
+	}
+	return parts
+}
+
+// saveImages renames the page images so they sort in reading order and moves
+// the folder to <bookID>_pages. Files are named NNNN_<pageID>.jpg where NNNN
+// is the 1-based position in the book, e.g. 0001_C1.jpg, 0002_I1.jpg,
+// 0003_1.jpg, ..., with the back cover (C3) last, or .png with ImageFormat
+// png. It returns the folder path.
+func (d *download) saveImages(pages []string) (string, error) {
+	for i, imgPath := range pages {
+		newPath := filepath.Join(d.fullpath, fmt.Sprintf("%04d_%s", i+1, filepath.Base(imgPath)))
+		if err := os.Rename(imgPath, newPath); err != nil {
+			return "", fmt.Errorf("error renaming image file: %w", err)
+		}
+	}
+
+	outDir := d.TargetPath()
+	if err := os.Rename(d.fullpath, outDir); err != nil {
+		return "", fmt.Errorf("error renaming image folder: %w", err)
+	}
+	d.outFiles = []string{outDir}
+	fmt.Fprintf(d.log, "Saved %d page images of book %s to %s\n", len(pages), d.id, outDir)
+	return outDir, nil
+}
+
+// pageParams returns the values of the URL template placeholders for a
+// page, with numbered pages padded to width digits, DefaultPageNrWidth if
+// width is 0
+func (b *Book) pageParams(pageNr string, width int) map[string]string {
+	params := map[string]string{
+		"book_id":      b.id,
+		"page_nr":      pageNr,
+		"long_page_nr": pageNr,
+	}
+	if _, err := strconv.Atoi(pageNr); err == nil {
+		// If pageNr is a number, pad it with zeros
+		params["long_page_nr"] = fmt.Sprintf("%0*s", cmp.Or(width, DefaultPageNrWidth), pageNr)
+
+		// Newspaper and periodical pages are prefixed with the issue date
+		if b.issueDate != "" {
+			params["long_page_nr"] = b.issueDate + "_" + params["long_page_nr"]
+		}
+	}
+	return params
+}
+
+// parseCookiesString parses a cookie string into http.Cookie objects
+func parseCookiesString(cookiesStr string) []*http.Cookie {
+	var cookies []*http.Cookie
+
+	if cookiesStr == "" {
+		return cookies
+	}
+
+	cookiePairs := strings.Split(cookiesStr, ";")
+	for _, pair := range cookiePairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			cookies = append(cookies, &http.Cookie{
+				Name:  parts[0],
+				Value: parts[1],
+				Path:  "/", // Set path to root
+			})
+		}
+	}
+
+	return cookies
+}
+
+// LoadCookies reads cookies from a file or a cookie string, preferring the file
+func LoadCookies(cookieFile, cookiesStr string) ([]*http.Cookie, error) {
+	if cookieFile != "" {
+		fileContent, err := readCookiesFromFile(cookieFile)
+		if err != nil {
+			return nil, err
+		}
+		return parseCookiesString(fileContent), nil
+	}
+	return parseCookiesString(cookiesStr), nil
+}
+
+// readCookiesFromFile reads cookies from a file
+func readCookiesFromFile(filepath string) (string, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return "", fmt.Errorf("error reading cookie file: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,106 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSplitPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []string
+		n     int
+		want  [][]string
+	}{
+		{"even", []string{"C1", "1", "2", "C3"}, 2, [][]string{{"C1", "1"}, {"2", "C3"}}},
+		{"odd", []string{"C1", "1", "2", "3", "C3"}, 2, [][]string{{"C1", "1"}, {"2", "3"}, {"C3"}}},
+		{"covers with intro pages", []string{"C1", "I1", "1", "2", "3", "C3"}, 4, [][]string{{"C1", "I1", "1", "2"}, {"3", "C3"}}},
+		{"no covers", []string{"1", "2", "3"}, 2, [][]string{{"1", "2"}, {"3"}}},
+		{"one page per part", []string{"C1", "1", "C3"}, 1, [][]string{{"C1"}, {"1"}, {"C3"}}},
+		{"fits in one part", []string{"C1", "1", "C3"}, 3, [][]string{{"C1", "1", "C3"}}},
+		{"no splitting", []string{"C1", "1", "C3"}, 0, [][]string{{"C1", "1", "C3"}}},
+		{"empty range", nil, 2, [][]string{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitPages(tt.pages, tt.n)
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("splitPages(%q, %d) = %q, want %q", tt.pages, tt.n, got, tt.want)
+			}
+			if len(tt.pages) > 0 {
+				// The covers stay in the first and last part
+				if first, last := got[0][0], got[len(got)-1][len(got[len(got)-1])-1]; first != tt.pages[0] || last != tt.pages[len(tt.pages)-1] {
+					t.Errorf("splitPages(%q, %d) starts with %s and ends with %s", tt.pages, tt.n, first, last)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadPageRateLimited(t *testing.T) {
+	var page bytes.Buffer
+	if err := jpeg.Encode(&page, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(page.Bytes())
+	}))
+	defer server.Close()
+
+	b := NewBook("2008011100001", DownloadOptions{BaseURL: server.URL, TempDir: t.TempDir()})
+	if err := os.MkdirAll(b.fullpath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := b.newDownload()
+	pc := d.newPageContext("C1")
+	if err := d.downloadPage(context.Background(), pc); err != nil {
+		t.Fatalf("downloadPage() = %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+	if _, err := os.Stat(b.pagePath("C1")); err != nil {
+		t.Errorf("page was not saved: %v", err)
+	}
+	// Waiting out a rate limit is not a failed attempt
+	if pc.retry != pageRetries {
+		t.Errorf("%d retries left after HTTP 429, want %d", pc.retry, pageRetries)
+	}
+}
+
+func TestDownloadPageRateLimitedGivesUp(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	b := NewBook("2008011100001", DownloadOptions{BaseURL: server.URL, TempDir: t.TempDir()})
+	d := b.newDownload()
+	err := d.downloadPage(context.Background(), d.newPageContext("C1"))
+	var netErr *NetworkError
+	if !errors.As(err, &netErr) || netErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("downloadPage() = %v, want a NetworkError with HTTP 429", err)
+	}
+	if n := requests.Load(); n != rateLimitRetries+1 {
+		t.Errorf("server got %d requests, want %d", n, rateLimitRetries+1)
+	}
+}
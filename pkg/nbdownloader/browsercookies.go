@@ -0,0 +1,378 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// Browsers lists the browsers cookies can be read from
+var Browsers = []string{"firefox", "chrome", "chromium", "edge"}
+
+// cookieDomain is the domain whose cookies are read from the browser
+const cookieDomain = "nb.no"
+
+// chromiumBrowser describes where a browser based on Chromium keeps its
+// profile and the name of its key in the system keychain
+type chromiumBrowser struct {
+	linuxDir   string // under ~/.config
+	macDir     string // under ~/Library/Application Support
+	windowsDir string // under %LOCALAPPDATA%
+	keyName    string // "<keyName> Safe Storage" in the macOS keychain
+	keyringApp string // application attribute of the key in the Linux keyring
+}
+
+var chromiumBrowsers = map[string]chromiumBrowser{
+	"chrome":   {"google-chrome", "Google/Chrome", `Google\Chrome\User Data`, "Chrome", "chrome"},
+	"chromium": {"chromium", "Chromium", `Chromium\User Data`, "Chromium", "chromium"},
+	"edge":     {"microsoft-edge", "Microsoft Edge", `Microsoft\Edge\User Data`, "Microsoft Edge", "microsoft-edge"},
+}
+
+// BrowserCookies reads the nb.no cookies of the most recently used profile
+// of a browser, one of Browsers, from its SQLite cookie database. Chrome,
+// Chromium and Edge encrypt their cookies with a key from the system
+// keychain on macOS and Linux and from DPAPI on Windows.
+func BrowserCookies(browser string) ([]*http.Cookie, error) {
+	if browser == "firefox" {
+		return firefoxCookies()
+	}
+	b, ok := chromiumBrowsers[browser]
+	if !ok {
+		return nil, fmt.Errorf("unknown browser %q, expected one of %s", browser, strings.Join(Browsers, ", "))
+	}
+	return chromiumCookies(browser, b)
+}
+
+// isNBCookie reports whether a cookie for host is sent to nb.no
+func isNBCookie(host string) bool {
+	host = strings.TrimPrefix(host, ".")
+	return host == cookieDomain || strings.HasSuffix(host, "."+cookieDomain)
+}
+
+// newestFile returns the most recently modified of the files matching the
+// patterns, which is the cookie database of the profile in use
+func newestFile(patterns ...string) (string, error) {
+	var newest string
+	var newestTime time.Time
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.ModTime().After(newestTime) {
+				newest, newestTime = path, info.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no cookie database found, looked for %s", patterns[0])
+	}
+	return newest, nil
+}
+
+// querySQLite copies a cookie database, which the running browser keeps
+// locked, and runs a query on the copy. It returns the columns of each row.
+func querySQLite(dbPath, query string) ([][][]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "nb-downloader-cookies")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Firefox keeps recent changes in the write-ahead log next to the database
+	copyPath := filepath.Join(tmpDir, "cookies.sqlite")
+	for _, suffix := range []string{"", "-wal"} {
+		if err := copyFile(dbPath+suffix, copyPath+suffix); err != nil && suffix == "" {
+			return nil, fmt.Errorf("error copying %s, close the browser and try again: %w", dbPath, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", copyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", dbPath, err)
+	}
+	defer db.Close()
+	result, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dbPath, err)
+	}
+	defer result.Close()
+	columns, err := result.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dbPath, err)
+	}
+
+	var rows [][][]byte
+	for result.Next() {
+		row := make([][]byte, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := result.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", dbPath, err)
+		}
+		rows = append(rows, row)
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", dbPath, err)
+	}
+	return rows, nil
+}
+
+// firefoxCookies reads the cookies of the most recently used Firefox
+// profile. Firefox stores them unencrypted.
+func firefoxCookies() ([]*http.Cookie, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	var profiles []string
+	switch runtime.GOOS {
+	case "windows":
+		profiles = []string{filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles")}
+	case "darwin":
+		profiles = []string{filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles")}
+	default:
+		profiles = []string{
+			filepath.Join(home, ".mozilla", "firefox"),
+			filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+		}
+	}
+	var patterns []string
+	for _, dir := range profiles {
+		patterns = append(patterns, filepath.Join(dir, "*", "cookies.sqlite"))
+	}
+	dbPath, err := newestFile(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := querySQLite(dbPath, "SELECT host, name, value, path FROM moz_cookies")
+	if err != nil {
+		return nil, err
+	}
+	var cookies []*http.Cookie
+	for _, row := range rows {
+		if len(row) == 4 && isNBCookie(string(row[0])) {
+			cookies = append(cookies, &http.Cookie{Name: string(row[1]), Value: string(row[2]), Path: string(row[3])})
+		}
+	}
+	return cookies, nil
+}
+
+// chromiumCookies reads the cookies of the most recently used profile of a
+// browser based on Chromium and decrypts them
+func chromiumCookies(name string, b chromiumBrowser) ([]*http.Cookie, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	var dataDir string
+	switch runtime.GOOS {
+	case "windows":
+		dataDir = filepath.Join(os.Getenv("LOCALAPPDATA"), b.windowsDir)
+	case "darwin":
+		dataDir = filepath.Join(home, "Library", "Application Support", b.macDir)
+	default:
+		dataDir = filepath.Join(home, ".config", b.linuxDir)
+	}
+	var patterns []string
+	for _, profile := range []string{"Default", "Profile *"} {
+		patterns = append(patterns,
+			filepath.Join(dataDir, profile, "Network", "Cookies"),
+			filepath.Join(dataDir, profile, "Cookies"))
+	}
+	dbPath, err := newestFile(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	// From database version 24 the value is prefixed with a hash of the host
+	var version int
+	if rows, err := querySQLite(dbPath, "SELECT value FROM meta WHERE key = 'version'"); err == nil && len(rows) == 1 {
+		version, _ = strconv.Atoi(string(rows[0][0]))
+	}
+	rows, err := querySQLite(dbPath, "SELECT host_key, name, value, encrypted_value, path FROM cookies")
+	if err != nil {
+		return nil, err
+	}
+
+	var decrypt func([]byte) ([]byte, error)
+	var cookies []*http.Cookie
+	for _, row := range rows {
+		if len(row) != 5 || !isNBCookie(string(row[0])) {
+			continue
+		}
+		value := row[2]
+		if len(row[3]) > 0 {
+			if decrypt == nil {
+				if decrypt, err = chromiumDecrypter(name, b, dataDir); err != nil {
+					return nil, err
+				}
+			}
+			if value, err = decrypt(row[3]); err != nil {
+				return nil, fmt.Errorf("error decrypting cookie %s: %w", row[1], err)
+			}
+			if version >= 24 && len(value) >= 32 {
+				value = value[32:]
+			}
+		}
+		cookies = append(cookies, &http.Cookie{Name: string(row[1]), Value: string(value), Path: string(row[4])})
+	}
+	return cookies, nil
+}
+
+// chromiumDecrypter returns the function that decrypts the cookie values
+// of a browser based on Chromium on this platform
+func chromiumDecrypter(name string, b chromiumBrowser, dataDir string) (func([]byte) ([]byte, error), error) {
+	switch runtime.GOOS {
+	case "windows":
+		key, err := chromiumWindowsKey(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) { return decryptGCM(key, data) }, nil
+	case "darwin":
+		out, err := exec.Command("security", "find-generic-password", "-w", "-s", b.keyName+" Safe Storage").Output()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the %s key from the keychain: %w", name, err)
+		}
+		key := pbkdf2SHA1(bytes.TrimSpace(out), []byte("saltysalt"), 1003, 16)
+		return func(data []byte) ([]byte, error) { return decryptCBC(key, data, nil) }, nil
+	}
+
+	// On Linux, v10 values use a fixed key and v11 values the key in the
+	// keyring, if the browser could reach one
+	v10 := pbkdf2SHA1([]byte("peanuts"), []byte("saltysalt"), 1, 16)
+	var v11 []byte
+	return func(data []byte) ([]byte, error) {
+		if !bytes.HasPrefix(data, []byte("v11")) {
+			return decryptCBC(v10, data, nil)
+		}
+		if v11 == nil {
+			out, err := exec.Command("secret-tool", "lookup", "application", b.keyringApp).Output()
+			if err != nil {
+				return nil, fmt.Errorf("error reading the %s key from the keyring with secret-tool: %w", name, err)
+			}
+			v11 = pbkdf2SHA1(bytes.TrimSpace(out), []byte("saltysalt"), 1, 16)
+		}
+		return decryptCBC(v11, data, v10)
+	}, nil
+}
+
+// decryptCBC decrypts a v10 or v11 cookie value of Chromium on macOS or
+// Linux, with fallback as a second key to try
+func decryptCBC(key, data, fallback []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("v10")) && !bytes.HasPrefix(data, []byte("v11")) {
+		return nil, fmt.Errorf("unsupported encryption %q", data[:min(len(data), 3)])
+	}
+	data = data[3:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid length")
+	}
+	for _, k := range [][]byte{key, fallback} {
+		if k == nil {
+			continue
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		plain := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, bytes.Repeat([]byte{' '}, aes.BlockSize)).CryptBlocks(plain, data)
+		// A wrong key shows in the PKCS#7 padding
+		pad := int(plain[len(plain)-1])
+		if pad >= 1 && pad <= aes.BlockSize && bytes.Count(plain[len(plain)-pad:], []byte{byte(pad)}) == pad {
+			return plain[:len(plain)-pad], nil
+		}
+	}
+	return nil, errors.New("wrong key")
+}
+
+// chromiumWindowsKey reads the AES key of the cookies from the Local State
+// file, where it is protected with DPAPI for the current user
+func chromiumWindowsKey(dataDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, "Local State"))
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		OSCrypt struct {
+			EncryptedKey string `json:"encrypted_key"`
+		} `json:"os_crypt"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing Local State: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(state.OSCrypt.EncryptedKey)
+	if err != nil || !bytes.HasPrefix(key, []byte("DPAPI")) {
+		return nil, errors.New("no DPAPI key in Local State")
+	}
+	return dpapiDecrypt(key[len("DPAPI"):])
+}
+
+// decryptGCM decrypts a v10 cookie value of Chromium on Windows. v20
+// values use app-bound encryption, which only the browser itself can undo.
+func decryptGCM(key, data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("v20")) {
+		return nil, errors.New("app-bound encryption (v20) is not supported, use -cookie-file instead")
+	}
+	if !bytes.HasPrefix(data, []byte("v10")) {
+		// Values from before Chrome 80 are protected with DPAPI directly
+		return dpapiDecrypt(data)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	data = data[3:]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid length")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// pbkdf2SHA1 derives a key from a password with PBKDF2-HMAC-SHA1 (RFC 8018)
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := slices.Clone(u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,107 @@
+package nbdownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// DefaultCacheDir returns the default page cache folder, nb-downloader in
+// the user's cache directory, e.g. ~/.cache/nb-downloader on Linux
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nb-downloader"), nil
+}
+
+// pageCache stores downloaded page images by the SHA-256 of their URL, so
+// that a page requested again, e.g. by a later download of the same book or
+// a cover shared by a publisher series, is not fetched from the server. The
+// URL includes the image width, so each width is cached separately. Since
+// nb.no answers pages without access with a placeholder image rather than
+// an error, only pages of a download that passed placeholder detection are
+// stored. A nil pageCache caches nothing.
+type pageCache struct {
+	dir string
+}
+
+// newPageCache returns a cache in dir, or nil if dir is empty
+func newPageCache(dir string) *pageCache {
+	if dir == "" {
+		return nil
+	}
+	return &pageCache{dir: dir}
+}
+
+// path returns the cache file of a URL. Files are spread over sub-folders
+// named after the first two hex digits of the hash to keep folders small.
+func (c *pageCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// read returns the cached image of a URL, if any
+func (c *pageCache) read(url string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(url))
+	return data, err == nil
+}
+
+// link places the cached image of a URL at dst and reports whether it was
+// cached. dst is hard-linked to the cache file where possible and copied
+// otherwise, e.g. when the cache is on another filesystem.
+func (c *pageCache) link(url, dst string) bool {
+	if c == nil {
+		return false
+	}
+	src := c.path(url)
+	if _, err := os.Stat(src); err != nil {
+		return false
+	}
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return true
+	}
+	return copyFile(src, dst) == nil
+}
+
+// store saves the image of a URL. The file is written under a temporary
+// name and renamed, so concurrent downloads never see a partial file.
+func (c *pageCache) store(url string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	path := c.path(url)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// remove deletes the cached image of a URL, e.g. after it failed
+// verification
+func (c *pageCache) remove(url string) {
+	if c == nil {
+		return
+	}
+	os.Remove(c.path(url))
+}
@@ -0,0 +1,259 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// socks5Servers are the local SOCKS5 proxies of the tests, one without
+// authentication and one requiring the username and password of
+// socks5Auth
+var socks5Servers struct {
+	open, auth *socks5Server
+}
+
+var socks5Auth = url.UserPassword("reader", "secret")
+
+func TestMain(m *testing.M) {
+	var err error
+	if socks5Servers.open, err = startSOCKS5Server(nil); err == nil {
+		socks5Servers.auth, err = startSOCKS5Server(socks5Auth)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error starting SOCKS5 server:", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	socks5Servers.open.Close()
+	socks5Servers.auth.Close()
+	os.Exit(code)
+}
+
+// socks5Server is a minimal SOCKS5 proxy after RFC 1928 that supports
+// CONNECT, with the username and password authentication of RFC 1929 if
+// user is set
+type socks5Server struct {
+	net.Listener
+	user      *url.Userinfo
+	connected atomic.Int32 // CONNECT requests served
+}
+
+func startSOCKS5Server(user *url.Userinfo) (*socks5Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &socks5Server{Listener: l, user: user}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	target, err := s.handshake(conn)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// handshake negotiates the authentication method, authenticates and
+// connects to the requested address
+func (s *socks5Server) handshake(conn net.Conn) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+	method := byte(0x00) // no authentication
+	if s.user != nil {
+		method = 0x02 // username and password
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		conn.Write([]byte{0x05, 0xff})
+		return nil, errors.New("no acceptable authentication method")
+	}
+	conn.Write([]byte{0x05, method})
+
+	if s.user != nil {
+		username, err := readSOCKS5String(conn, 1)
+		if err != nil {
+			return nil, err
+		}
+		password, err := readSOCKS5String(conn, 0)
+		if err != nil {
+			return nil, err
+		}
+		if want, _ := s.user.Password(); username != s.user.Username() || password != want {
+			conn.Write([]byte{0x01, 0x01})
+			return nil, errors.New("wrong username or password")
+		}
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	var host string
+	switch request[3] {
+	case 0x01: // IPv4
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 0x03: // domain name
+		name, err := readSOCKS5String(conn, 0)
+		if err != nil {
+			return nil, err
+		}
+		host = name
+	default:
+		conn.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	if request[1] != 0x01 {
+		conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, errors.New("unsupported command")
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	s.connected.Add(1)
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return target, nil
+}
+
+// readSOCKS5String reads a string prefixed with its length, after skipping
+// skip bytes
+func readSOCKS5String(r io.Reader, skip int) (string, error) {
+	buf := make([]byte, skip+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	s := make([]byte, buf[skip])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "page")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		server  *socks5Server
+		user    *url.Userinfo
+		wantErr bool
+	}{
+		{"no auth", socks5Servers.open, nil, false},
+		{"user:pass auth", socks5Servers.auth, socks5Auth, false},
+		{"wrong password", socks5Servers.auth, url.UserPassword("reader", "guess"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, err := ParseProxy((&url.URL{Scheme: "socks5", Host: tt.server.Addr().String(), User: tt.user}).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := tt.server.connected.Load()
+			b := NewBook("2008011100001", DownloadOptions{BaseURL: server.URL, Proxy: proxyURL})
+			resp, err := b.get(context.Background(), http.MethodGet, server.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request through the proxy succeeded with the wrong password")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request through the proxy failed: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(body) != "page" {
+				t.Errorf("got %q, %v through the proxy, want %q", body, err, "page")
+			}
+			if n := tt.server.connected.Load() - before; n != 1 {
+				t.Errorf("proxy served %d connections, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCheckAuthPublicBookKeepsSharedCookies(t *testing.T) {
+	var withCookies int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Cookies()) > 0 {
+			withCookies++
+		}
+	}))
+	defer server.Close()
+
+	b := NewBook("2008011100001", DownloadOptions{
+		BaseURL: server.URL,
+		Cookies: []*http.Cookie{{Name: "session", Value: "secret"}},
+	})
+	clone := b.Clone("2008011100002")
+	d := b.newDownload()
+	if err := d.checkAuth(context.Background()); err != nil {
+		t.Fatalf("checkAuth() = %v", err)
+	}
+
+	// The public book is downloaded without cookies
+	resp, err := d.get(context.Background(), http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if withCookies != 0 {
+		t.Errorf("public book sent cookies")
+	}
+	// while the book itself and its clone keep them
+	if !b.hasCookies() {
+		t.Errorf("book lost the cookies")
+	}
+	if !clone.hasCookies() {
+		t.Errorf("clone lost the cookies")
+	}
+	if _, ok := clone.client.Jar.(*CookieChangeListener); !ok {
+		t.Errorf("clone lost the cookie change listener, jar is %T", clone.client.Jar)
+	}
+}
@@ -0,0 +1,80 @@
+package nbdownloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CookieChangeListener is an http.CookieJar that stores cookies in another
+// jar, usually a cookiejar.Jar, and calls OnChange with every cookie a
+// server sets
+type CookieChangeListener struct {
+	http.CookieJar
+	OnChange func(u *url.URL, cookies []*http.Cookie)
+}
+
+// SetCookies stores the cookies and passes them on to OnChange
+func (l *CookieChangeListener) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	l.CookieJar.SetCookies(u, cookies)
+	if l.OnChange != nil && len(cookies) > 0 {
+		l.OnChange(u, cookies)
+	}
+}
+
+// sessionCookies keeps track of the user's cookies that the server replaced
+// during a download, e.g. when it extends the session
+type sessionCookies struct {
+	url   *url.URL       // where the cookies are sent
+	given []*http.Cookie // cookies given by the user
+	log   io.Writer
+
+	mu        sync.Mutex
+	refreshed map[string]bool // names of the replaced cookies
+}
+
+// changed logs the cookies set by the server that supersede one given by
+// the user
+func (s *sessionCookies) changed(u *url.URL, cookies []*http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range cookies {
+		for _, given := range s.given {
+			if c.Name != given.Name || c.Value == given.Value || c.MaxAge < 0 {
+				continue
+			}
+			if !s.refreshed[c.Name] {
+				fmt.Fprintf(s.log, "The server refreshed the session cookie %s\n", c.Name)
+			}
+			if s.refreshed == nil {
+				s.refreshed = make(map[string]bool)
+			}
+			s.refreshed[c.Name] = true
+		}
+	}
+}
+
+// RefreshedCookies returns the cookies of the session in the format of
+// -cookies, 'name1=value1; name2=value2', if the server replaced any of the
+// cookies given in DownloadOptions during the download, and "" otherwise.
+// Passing them to the next download keeps the session alive.
+func (b *Book) RefreshedCookies() string {
+	if b.cookies == nil || b.client.Jar == nil {
+		return ""
+	}
+	b.cookies.mu.Lock()
+	refreshed := len(b.cookies.refreshed) > 0
+	b.cookies.mu.Unlock()
+	if !refreshed {
+		return ""
+	}
+
+	var pairs []string
+	for _, c := range b.client.Jar.Cookies(b.cookies.url) {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
@@ -0,0 +1,116 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// coverTemplatePage is the page ID of the page rendered from the cover
+// template, which is saved in the temporary folder like the downloaded pages
+const coverTemplatePage = "cover_template"
+
+// The cover template is laid out on an A4 page at the CSS resolution of
+// 96 dpi, so that mm units in the template match the printed page, and
+// rendered at twice that resolution
+const (
+	coverWindowWidth  = 794
+	coverWindowHeight = 1123
+	coverScale        = 2
+)
+
+// chromeNames are the executables searched for in PATH when CHROME_PATH is
+// not set
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// CoverData is passed to a cover template. The metadata fields are
+// available directly, e.g. {{.Title}} or {{range .Authors}}.
+type CoverData struct {
+	*Metadata
+	Date string // day of the download, YYYY-MM-DD
+}
+
+// findChrome returns the headless browser used to render cover templates:
+// $CHROME_PATH if set, otherwise the first Chrome or Chromium in PATH
+func findChrome() (string, error) {
+	if path := os.Getenv("CHROME_PATH"); path != "" {
+		return path, nil
+	}
+	for _, name := range chromeNames {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no Chrome or Chromium found in PATH, set CHROME_PATH to the browser executable")
+}
+
+// renderCoverTemplate executes the HTML template at b.coverTemplate with the
+// book's metadata, takes a screenshot of it with headless Chrome and saves
+// it as a page image in the temporary folder. It returns the page's path.
+func (b *Book) renderCoverTemplate(ctx context.Context) (string, error) {
+	tmpl, err := template.ParseFiles(b.coverTemplate)
+	if err != nil {
+		return "", err
+	}
+	chrome, err := findChrome()
+	if err != nil {
+		return "", err
+	}
+
+	// The HTML is written next to the template so that relative links to
+	// images and stylesheets keep working
+	html, err := os.CreateTemp(filepath.Dir(b.coverTemplate), ".nb-downloader-cover-*.html")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(html.Name())
+	data := CoverData{Metadata: b.Metadata(ctx), Date: time.Now().Format(time.DateOnly)}
+	if err := tmpl.Execute(html, data); err != nil {
+		html.Close()
+		return "", err
+	}
+	if err := html.Close(); err != nil {
+		return "", err
+	}
+	htmlPath, err := filepath.Abs(html.Name())
+	if err != nil {
+		return "", err
+	}
+
+	pngPath := filepath.Join(b.fullpath, coverTemplatePage+".png")
+	defer os.Remove(pngPath)
+	cmd := exec.CommandContext(ctx, chrome,
+		"--headless",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		fmt.Sprintf("--window-size=%d,%d", coverWindowWidth, coverWindowHeight),
+		fmt.Sprintf("--force-device-scale-factor=%d", coverScale),
+		"--screenshot="+pngPath,
+		"file://"+filepath.ToSlash(htmlPath))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%s failed: %w\n%s", filepath.Base(chrome), err, bytes.TrimSpace(out))
+	}
+
+	f, err := os.Open(pngPath)
+	if err != nil {
+		return "", fmt.Errorf("no screenshot written by %s: %w", filepath.Base(chrome), err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("error decoding screenshot: %w", err)
+	}
+
+	outPath := b.pagePath(coverTemplatePage)
+	if err := saveImage(outPath, img); err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
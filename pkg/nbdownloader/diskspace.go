@@ -0,0 +1,74 @@
+package nbdownloader
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// estimatedBytesPerPage is a rough JPEG size for one page at the default 602px width
+const estimatedBytesPerPage = 512 * 1024
+
+// pngSizeFactor is roughly how much larger a scanned page is as a PNG than as
+// a JPEG. The PDF holds the pixels deflated like the PNG, so the output grows
+// by the same factor.
+const pngSizeFactor = 4
+
+// estimatedDiskUsage estimates the disk space needed for the page images plus
+// the final output file, which holds roughly the same amount of image data
+func (b *Book) estimatedDiskUsage() int64 {
+	start, end := b.pageRange()
+	pages := int64(end - start + 3) // numbered pages plus front and back cover
+	perPage := int64(estimatedBytesPerPage)
+
+	// JPEG size grows with the image area
+	width := int64(b.imageWidth)
+	if width > 0 {
+		perPage = perPage * width * width / (DefaultImageWidth * DefaultImageWidth)
+	}
+	if b.imageFormat == "png" {
+		perPage *= pngSizeFactor
+	}
+
+	return pages * perPage * 2
+}
+
+// checkDiskSpace warns when the estimated download size does not fit on disk
+// and asks whether to continue. It returns false to abort.
+func (b *Book) checkDiskSpace() bool {
+	dir, err := filepath.Abs(filepath.Dir(b.fullpath))
+	if err != nil {
+		return true
+	}
+
+	available, err := availableDiskSpace(dir)
+	if err != nil {
+		fmt.Fprintln(b.log, "Could not determine available disk space:", err)
+		return true
+	}
+
+	estimate := b.estimatedDiskUsage()
+	if available >= estimate+estimate/10 {
+		return true
+	}
+
+	fmt.Fprintf(b.log, "WARNING: this download needs an estimated %s but only %s is available in %s\n",
+		FormatBytes(estimate), FormatBytes(available), dir)
+	if b.assumeYes {
+		return true
+	}
+	return b.confirm != nil && b.confirm("Continue anyway?")
+}
+
+// FormatBytes renders a byte count in human-readable units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
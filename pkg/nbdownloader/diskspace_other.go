@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package nbdownloader
+
+import "errors"
+
+// availableDiskSpace is not implemented on this platform
+func availableDiskSpace(path string) (int64, error) {
+	return 0, errors.New("disk space check not supported on this platform")
+}
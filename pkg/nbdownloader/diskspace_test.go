@@ -0,0 +1,30 @@
+package nbdownloader
+
+import "testing"
+
+func TestEstimatedDiskUsage(t *testing.T) {
+	const jpeg = estimatedBytesPerPage * 2 // page image plus its share of the output
+	tests := []struct {
+		name string
+		opts DownloadOptions
+		want int64
+	}{
+		{"default width", DownloadOptions{Length: 10}, 12 * jpeg},
+		{"double width", DownloadOptions{Length: 10, ImageWidth: 2 * DefaultImageWidth}, 12 * jpeg * 4},
+		{"half width", DownloadOptions{Length: 10, ImageWidth: DefaultImageWidth / 2}, 12 * jpeg / 4},
+		{"png", DownloadOptions{Length: 10, ImageFormat: "png"}, 12 * jpeg * pngSizeFactor},
+		{"png double width", DownloadOptions{Length: 10, ImageFormat: "png", ImageWidth: 2 * DefaultImageWidth}, 12 * jpeg * 4 * pngSizeFactor},
+		{"page range", DownloadOptions{Length: 100, StartPage: 11, EndPage: 20}, 12 * jpeg},
+		{"single page", DownloadOptions{Length: 100, StartPage: 5, EndPage: 5}, 3 * jpeg},
+		{"epub", DownloadOptions{Length: 10, Format: "epub"}, 12 * jpeg},
+		{"images", DownloadOptions{Length: 10, Format: "images"}, 12 * jpeg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBook("2008011100001", tt.opts)
+			if got := b.estimatedDiskUsage(); got != tt.want {
+				t.Errorf("estimatedDiskUsage() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Package nbdownloader downloads books, newspapers and periodicals from the
+// Norwegian National Library (nb.no) and saves them as PDF, EPUB or a folder
+// of page images.
+//
+// A download is configured with DownloadOptions and started with
+// Book.Download:
+//
+//	b := nbdownloader.NewBook("2010101408082", nbdownloader.DownloadOptions{
+//		Format: "epub",
+//		Log:    os.Stdout,
+//	})
+//	if err := b.Download(context.Background()); err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Println("Saved", b.OutputPath())
+//
+// Books that require a login at nb.no need the session cookies of a browser
+// in DownloadOptions.Cookies, see LoadCookies.
+//
+// Several books with the same options are downloaded with clones of one
+// Book, which share its HTTP connections and cookies and can run in
+// separate goroutines:
+//
+//	for _, id := range ids {
+//		c := b.Clone(id)
+//		go c.Download(ctx)
+//	}
+package nbdownloader
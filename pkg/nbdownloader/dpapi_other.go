@@ -0,0 +1,10 @@
+//go:build !windows
+
+package nbdownloader
+
+import "errors"
+
+// dpapiDecrypt is only available on Windows
+func dpapiDecrypt(data []byte) ([]byte, error) {
+	return nil, errors.New("DPAPI is only available on Windows")
+}
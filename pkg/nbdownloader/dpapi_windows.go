@@ -0,0 +1,34 @@
+//go:build windows
+
+package nbdownloader
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCryptUnprotectData = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptUnprotectData")
+	procLocalFree          = syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+)
+
+// dataBlob is the DATA_BLOB structure of the Windows API
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+// dpapiDecrypt decrypts data protected with DPAPI for the current user
+func dpapiDecrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	in := dataBlob{size: uint32(len(data)), data: &data[0]}
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+	return append([]byte(nil), unsafe.Slice(out.data, out.size)...), nil
+}
@@ -0,0 +1,206 @@
+package nbdownloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const epubContainerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubWriter builds a fixed-layout EPUB3 file with one image per page
+type epubWriter struct {
+	file       *os.File
+	zip        *zip.Writer
+	title      string
+	identifier string
+	pages      []string // page numbers in reading order, e.g. "0001"
+	images     []string // image file names of the pages, e.g. "0001.jpg"
+}
+
+// newEPUBWriter creates the EPUB file and writes the container entries
+func newEPUBWriter(path, title, identifier string) (*epubWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating EPUB file: %w", err)
+	}
+
+	w := &epubWriter{
+		file:       f,
+		zip:        zip.NewWriter(f),
+		title:      title,
+		identifier: identifier,
+	}
+
+	// The mimetype entry must come first and be stored uncompressed
+	mt, err := w.zip.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = io.WriteString(mt, "application/epub+zip")
+	}
+	if err == nil {
+		err = w.writeFile("META-INF/container.xml", epubContainerXML)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing EPUB container: %w", err)
+	}
+
+	return w, nil
+}
+
+// writeFile adds a compressed text entry to the archive
+func (w *epubWriter) writeFile(name, content string) error {
+	fw, err := w.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(fw, content)
+	return err
+}
+
+// addPage copies a JPEG or PNG image into the archive and adds an XHTML page
+// showing it
+func (w *epubWriter) addPage(imgPath string) error {
+	data, err := os.ReadFile(imgPath)
+	if err != nil {
+		return fmt.Errorf("error reading image file: %w", err)
+	}
+
+	page := fmt.Sprintf("%04d", len(w.pages)+1)
+	image := page + filepath.Ext(imgPath)
+	fw, err := w.zip.Create("OEBPS/images/" + image)
+	if err != nil {
+		return fmt.Errorf("error adding image to EPUB: %w", err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		return fmt.Errorf("error adding image to EPUB: %w", err)
+	}
+
+	xhtml := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>` + page + `</title><style>body{margin:0}img{width:100%;height:100%;object-fit:contain}</style></head>
+<body><img src="../images/` + image + `" alt="Page ` + page + `"/></body>
+</html>
+`
+	if err := w.writeFile("OEBPS/pages/"+page+".xhtml", xhtml); err != nil {
+		return fmt.Errorf("error adding page to EPUB: %w", err)
+	}
+
+	w.pages = append(w.pages, page)
+	w.images = append(w.images, image)
+	return nil
+}
+
+// close writes the package document and navigation and finishes the archive
+func (w *epubWriter) close() error {
+	defer w.file.Close()
+
+	if err := w.writeFile("OEBPS/content.opf", w.contentOPF()); err != nil {
+		return fmt.Errorf("error writing EPUB package: %w", err)
+	}
+	if err := w.writeFile("OEBPS/nav.xhtml", w.navXHTML()); err != nil {
+		return fmt.Errorf("error writing EPUB navigation: %w", err)
+	}
+	if err := w.zip.Close(); err != nil {
+		return fmt.Errorf("error finishing EPUB file: %w", err)
+	}
+	return nil
+}
+
+// contentOPF renders OEBPS/content.opf
+func (w *epubWriter) contentOPF() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&sb, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", xmlEscape(w.identifier))
+	fmt.Fprintf(&sb, "    <dc:title>%s</dc:title>\n", xmlEscape(w.title))
+	sb.WriteString("    <dc:language>no</dc:language>\n")
+	fmt.Fprintf(&sb, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	sb.WriteString(`    <meta property="rendition:layout">pre-paginated</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+`)
+	for i, page := range w.pages {
+		props := ""
+		if i == 0 {
+			props = ` properties="cover-image"`
+		}
+		image := w.images[i]
+		fmt.Fprintf(&sb, "    <item id=\"img%s\" href=\"images/%s\" media-type=\"%s\"%s/>\n", page, image, mime.TypeByExtension(filepath.Ext(image)), props)
+		fmt.Fprintf(&sb, "    <item id=\"page%s\" href=\"pages/%s.xhtml\" media-type=\"application/xhtml+xml\"/>\n", page, page)
+	}
+	sb.WriteString("  </manifest>\n  <spine>\n")
+	for _, page := range w.pages {
+		fmt.Fprintf(&sb, "    <itemref idref=\"page%s\"/>\n", page)
+	}
+	sb.WriteString("  </spine>\n</package>\n")
+	return sb.String()
+}
+
+// navXHTML renders OEBPS/nav.xhtml with a link to every page
+func (w *epubWriter) navXHTML() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>`)
+	sb.WriteString(xmlEscape(w.title))
+	sb.WriteString(`</title></head>
+<body>
+  <nav epub:type="toc">
+    <ol>
+`)
+	for _, page := range w.pages {
+		fmt.Fprintf(&sb, "      <li><a href=\"pages/%s.xhtml\">Page %s</a></li>\n", page, page)
+	}
+	sb.WriteString("    </ol>\n  </nav>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// xmlEscape escapes text for use in XML content and attributes
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// saveEPUB combines the page images into a single EPUB file and returns its path
+func (d *download) saveEPUB(ctx context.Context, pages []string) (string, error) {
+	fmt.Fprintln(d.log, "Creating EPUB...")
+
+	outPath := d.TargetPath()
+	w, err := newEPUBWriter(outPath, d.Metadata(ctx).Title, d.urn())
+	if err != nil {
+		return "", err
+	}
+	for _, imgPath := range pages {
+		if err := w.addPage(imgPath); err != nil {
+			w.close()
+			return "", err
+		}
+	}
+	if err := w.close(); err != nil {
+		return "", err
+	}
+	d.outFiles = []string{outPath}
+	fmt.Fprintln(d.log, "EPUB saved of book", d.id)
+	return outPath, nil
+}
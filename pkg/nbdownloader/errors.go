@@ -0,0 +1,81 @@
+package nbdownloader
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCancelled is returned by Download when the user declines to continue
+var ErrCancelled = errors.New("download cancelled")
+
+// AuthError reports that nb.no refused access to a page (HTTP 401 or 403)
+type AuthError struct {
+	Page       string
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("page %s: access denied (HTTP Status %d)", e.Page, e.StatusCode)
+}
+
+// NetworkError reports a failed request, an unexpected HTTP status or a
+// truncated response
+type NetworkError struct {
+	Page       string
+	URL        string
+	StatusCode int // 0 if no response was received
+	Err        error
+}
+
+func (e *NetworkError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("page %s: %v", e.Page, e.Err)
+	}
+	return fmt.Sprintf("page %s: HTTP Status %d", e.Page, e.StatusCode)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// PageNotFoundError reports that a page does not exist (HTTP 404)
+type PageNotFoundError struct {
+	Page string
+	URL  string
+}
+
+func (e *PageNotFoundError) Error() string {
+	return fmt.Sprintf("page %s: not found", e.Page)
+}
+
+// StorageError reports a failure to write downloaded data to disk
+type StorageError struct {
+	Path string
+	Err  error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("error writing %s: %v", e.Path, e.Err)
+}
+
+func (e *StorageError) Unwrap() error { return e.Err }
+
+// PlaceholderError reports that more consecutive pages than a real book would
+// have were the same image, which nb.no serves in place of pages the user has
+// no access to. It is only returned with FailOnDuplicates.
+type PlaceholderError struct {
+	FirstPage string
+	LastPage  string
+}
+
+func (e *PlaceholderError) Error() string {
+	return fmt.Sprintf("pages %s to %s: identical images, possibly a placeholder for denied access", e.FirstPage, e.LastPage)
+}
+
+// isFatal reports whether a page error should stop the whole download.
+// Authentication and storage failures will affect every following page, so
+// there is no point in continuing; missing pages and network hiccups are
+// limited to the page at hand.
+func isFatal(err error) bool {
+	var authErr *AuthError
+	var storageErr *StorageError
+	return errors.As(err, &authErr) || errors.As(err, &storageErr)
+}
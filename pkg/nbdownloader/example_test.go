@@ -0,0 +1,44 @@
+package nbdownloader_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader"
+)
+
+func ExampleNewBook() {
+	b := nbdownloader.NewBook("2010101408082", nbdownloader.DownloadOptions{
+		Format:     "epub",
+		OutputName: "hamsun_sult",
+	})
+	fmt.Println(b.TargetPath())
+	fmt.Println(b.PageURL("C1"))
+	fmt.Println(b.PageURL("7"))
+	// Output:
+	// hamsun_sult.epub
+	// https://www.nb.no/services/image/resolver/URN:NBN:no-nb_digibok_2010101408082_C1/full/602,/0/default.jpg
+	// https://www.nb.no/services/image/resolver/URN:NBN:no-nb_digibok_2010101408082_0007/full/602,/0/default.jpg
+}
+
+func ExampleBook_Download() {
+	cookies, err := nbdownloader.LoadCookies("cookies.txt", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := nbdownloader.NewBook("2010101408082", nbdownloader.DownloadOptions{
+		Cookies:   cookies,
+		StartPage: 1,
+		EndPage:   20,
+		Log:       os.Stderr,
+	})
+	if err := b.Download(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Saved %d pages to %s\n", b.PageCount(), b.OutputPath())
+	for _, err := range b.PageErrors() {
+		fmt.Println("Missing:", err)
+	}
+}
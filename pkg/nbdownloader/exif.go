@@ -0,0 +1,92 @@
+package nbdownloader
+
+import (
+	"image"
+	"image/draw"
+	"os"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// readEXIFOrientation returns the EXIF orientation (1-8) of a JPEG file, or 1
+// if the file has no orientation tag or its EXIF block can't be read
+func readEXIFOrientation(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1, err
+	}
+	defer f.Close()
+
+	// Decode returns the tags it could read along with the error of a
+	// broken sub-IFD; the orientation is in the first IFD
+	x, _ := exif.Decode(f)
+	if x == nil {
+		return 1, nil
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1, nil
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1, nil
+	}
+	return orientation, nil
+}
+
+// correctOrientation decodes a page image and rotates or flips it according to its
+// EXIF orientation so it displays upright. It returns nil if the image
+// cannot be decoded.
+func correctOrientation(imgPath string) image.Image {
+	img, err := loadImage(imgPath)
+	if err != nil {
+		return nil
+	}
+	orientation, _ := readEXIFOrientation(imgPath)
+	return applyOrientation(img, orientation)
+}
+
+// applyOrientation transforms an image stored with the given EXIF
+// orientation into its upright form
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := image.NewRGBA(img.Bounds())
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored horizontally, rotated 270° clockwise
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored horizontally, rotated 90° clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 270° clockwise
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x+src.Rect.Min.X, y+src.Rect.Min.Y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,105 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tiffOrientation returns a TIFF structure whose first IFD holds only the
+// orientation tag, in the byte order order
+func tiffOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8)) // offset of the first IFD
+	binary.Write(&b, order, uint16(1)) // entries
+	binary.Write(&b, order, uint16(0x0112))
+	binary.Write(&b, order, uint16(3)) // SHORT
+	binary.Write(&b, order, uint32(1))
+	binary.Write(&b, order, orientation)
+	binary.Write(&b, order, uint16(0)) // padding of the value
+	binary.Write(&b, order, uint32(0)) // no next IFD
+	return b.Bytes()
+}
+
+// writeJPEGWithAPP1 writes a small JPEG with an APP1 segment holding app1
+// right after the start of image marker and returns its path
+func writeJPEGWithAPP1(t *testing.T, app1 []byte) string {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&b, binary.BigEndian, uint16(len(app1)+2))
+	b.Write(app1)
+	b.Write(img.Bytes()[2:])
+
+	path := filepath.Join(t.TempDir(), "page.jpg")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadEXIFOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := uint16(1); orientation <= 8; orientation++ {
+			path := writeJPEGWithAPP1(t, append([]byte("Exif\x00\x00"), tiffOrientation(order, orientation)...))
+			got, err := readEXIFOrientation(path)
+			if err != nil || got != int(orientation) {
+				t.Errorf("readEXIFOrientation() of orientation %d in %s = %d, %v", orientation, order, got, err)
+			}
+		}
+	}
+}
+
+func TestReadEXIFOrientationBroken(t *testing.T) {
+	valid := append([]byte("Exif\x00\x00"), tiffOrientation(binary.BigEndian, 6)...)
+	badOffset := bytes.Clone(valid)
+	binary.BigEndian.PutUint32(badOffset[6+4:], 0xFFFF)
+	tests := []struct {
+		name string
+		app1 []byte
+	}{
+		{"empty", nil},
+		{"no EXIF header", []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")},
+		{"truncated header", []byte("Exif\x00")},
+		{"no TIFF", []byte("Exif\x00\x00")},
+		{"truncated TIFF header", []byte("Exif\x00\x00II*\x00")},
+		{"truncated IFD", valid[:len(valid)-10]},
+		{"IFD outside the block", badOffset},
+		{"unknown byte order", append([]byte("Exif\x00\x00XX"), valid[8:]...)},
+		{"garbage", bytes.Repeat([]byte{0xFF, 0x00, 0x12, 0xE1}, 40)},
+		{"invalid orientation", append([]byte("Exif\x00\x00"), tiffOrientation(binary.LittleEndian, 9)...)},
+	}
+	for _, tt := range tests {
+		got, err := readEXIFOrientation(writeJPEGWithAPP1(t, tt.app1))
+		if err != nil || got != 1 {
+			t.Errorf("readEXIFOrientation() with %s APP1 = %d, %v, want 1", tt.name, got, err)
+		}
+	}
+
+	// An APP1 segment cut off by the end of the file
+	path := filepath.Join(t.TempDir(), "truncated.jpg")
+	if err := os.WriteFile(path, append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10, 0x00}, valid[:12]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readEXIFOrientation(path); err != nil || got != 1 {
+		t.Errorf("readEXIFOrientation() with a truncated file = %d, %v, want 1", got, err)
+	}
+
+	if _, err := readEXIFOrientation(filepath.Join(t.TempDir(), "missing.jpg")); err == nil {
+		t.Error("readEXIFOrientation() of a missing file succeeded")
+	}
+}
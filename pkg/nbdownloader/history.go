@@ -0,0 +1,146 @@
+package nbdownloader
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// historySchema creates the table of the download history. Times are
+// stored in UTC as RFC 3339, which sorts in time order.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS downloads (
+	id         INTEGER PRIMARY KEY,
+	book_id    TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	pages      INTEGER NOT NULL,
+	path       TEXT NOT NULL,
+	downloaded TEXT NOT NULL,
+	sha256     TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS downloads_book ON downloads (book_id, type);`
+
+// HistoryEntry is one completed download in the download history. Unlike
+// the index, which keeps the latest download of each book, the history
+// keeps every download.
+type HistoryEntry struct {
+	ID         string
+	Type       string
+	Pages      int
+	Path       string
+	Downloaded time.Time
+	SHA256     string // of the PDF or EPUB, empty for image folders
+}
+
+// OpenHistory opens the SQLite download history at path, creating it if
+// needed
+func OpenHistory(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating data directory: %w", err)
+	}
+	// Other processes may be recording a download at the same time
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("error opening history: %w", err)
+	}
+	// One connection serializes the books downloaded in parallel
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening history %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// RecordHistory adds the completed download of the book to the history in
+// db, with the SHA-256 of its output file
+func (b *Book) RecordHistory(db *sql.DB) error {
+	outPath := b.OutputPath()
+	if abs, err := filepath.Abs(outPath); err == nil {
+		outPath = abs
+	}
+
+	var hash string
+	if info, err := os.Stat(outPath); err == nil && info.Mode().IsRegular() {
+		if hash, err = hashFile(outPath); err != nil {
+			return fmt.Errorf("error hashing output: %w", err)
+		}
+	}
+
+	_, err := db.Exec("INSERT INTO downloads (book_id, type, pages, path, downloaded, sha256) VALUES (?, ?, ?, ?, ?, ?)",
+		b.id, b.DocumentType(), b.PageCount(), outPath, time.Now().UTC().Format(time.RFC3339), hash)
+	if err != nil {
+		return fmt.Errorf("error updating download history: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SearchHistory returns the downloads in db whose book ID or path contains
+// query, ignoring case, newest first. An empty query matches every download.
+// limit caps the number of entries if positive.
+func SearchHistory(db *sql.DB, query string, limit int) ([]HistoryEntry, error) {
+	if limit <= 0 {
+		limit = -1 // no limit in SQLite
+	}
+	return queryHistory(db, `SELECT book_id, type, pages, path, downloaded, sha256 FROM downloads
+		WHERE instr(lower(book_id), lower(?1)) > 0 OR instr(lower(path), lower(?1)) > 0
+		ORDER BY downloaded DESC, id DESC LIMIT ?2`, query, limit)
+}
+
+// LastDownload returns the latest download of the book as docType in db, if
+// any
+func LastDownload(db *sql.DB, id, docType string) (HistoryEntry, bool, error) {
+	entries, err := queryHistory(db, `SELECT book_id, type, pages, path, downloaded, sha256 FROM downloads
+		WHERE book_id = ? AND type = ? ORDER BY downloaded DESC, id DESC LIMIT 1`, id, docType)
+	if err != nil || len(entries) == 0 {
+		return HistoryEntry{}, false, err
+	}
+	return entries[0], true, nil
+}
+
+// queryHistory runs a query for the columns of HistoryEntry
+func queryHistory(db *sql.DB, query string, args ...any) ([]HistoryEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var downloaded string
+		if err := rows.Scan(&e.ID, &e.Type, &e.Pages, &e.Path, &downloaded, &e.SHA256); err != nil {
+			return nil, fmt.Errorf("error reading history: %w", err)
+		}
+		if e.Downloaded, err = time.Parse(time.RFC3339, downloaded); err != nil {
+			return nil, fmt.Errorf("error reading history: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	return entries, nil
+}
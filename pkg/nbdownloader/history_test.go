@@ -0,0 +1,55 @@
+package nbdownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordHistory(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenHistory(filepath.Join(dir, "nb-downloader", "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pdf := []byte("%PDF-1.4")
+	for _, id := range []string{"2008011100001", "2008011100002", "2008011100001"} {
+		b := NewBook(id, DownloadOptions{})
+		b.last = b.newDownload()
+		b.last.outPath = filepath.Join(dir, id+".pdf")
+		b.last.pageCount = 3
+		if err := os.WriteFile(b.last.outPath, pdf, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.RecordHistory(db); err != nil {
+			t.Fatalf("RecordHistory() = %v", err)
+		}
+	}
+
+	entries, err := SearchHistory(db, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first
+	if len(entries) != 2 || entries[0].ID != "2008011100001" || entries[1].ID != "2008011100002" {
+		t.Fatalf("SearchHistory(\"\", 2) = %+v, want the last two downloads", entries)
+	}
+	sum := sha256.Sum256(pdf)
+	if e := entries[0]; e.Pages != 3 || e.Type != "digibok" || e.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("recorded %+v", e)
+	}
+
+	if entries, err = SearchHistory(db, "100001.PDF", 0); err != nil || len(entries) != 2 {
+		t.Errorf("SearchHistory by path found %d downloads, %v, want 2", len(entries), err)
+	}
+	if _, ok, err := LastDownload(db, "2008011100002", "digibok"); !ok || err != nil {
+		t.Errorf("LastDownload() = %v, %v, want the recorded download", ok, err)
+	}
+	if _, ok, err := LastDownload(db, "2008011100002", "digavis"); ok || err != nil {
+		t.Errorf("LastDownload() of another type = %v, %v, want none", ok, err)
+	}
+}
@@ -0,0 +1,247 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// CMYK pages are converted from RGB with color.CMYKModel, which has no
+// notion of ink or paper. So that a printer can still reproduce the colors
+// of the scan, the PDF embeds an ICC profile describing that conversion:
+// its A2B0 table maps CMYK to CIELAB through sRGB, and its B2A0 table does
+// the reverse.
+
+// Grid points per channel of the profile's lookup tables
+const (
+	iccCMYKGridPoints = 9  // 9^4 CMYK samples
+	iccLabGridPoints  = 17 // 17^3 Lab samples
+)
+
+// d50 is the ICC profile connection space white point
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// cmykProfile returns the ICC profile of CMYK pages, built on first use
+var cmykProfile = sync.OnceValue(buildCMYKProfile)
+
+// buildCMYKProfile builds a version 2.1 output profile with CMYK data and a
+// CIELAB profile connection space
+func buildCMYKProfile() []byte {
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", iccTextDescription("nb-downloader sRGB CMYK")},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(d50)},
+		{"A2B0", iccLut8(4, 3, iccCMYKGridPoints, cmykToLab8)},
+		{"B2A0", iccLut8(3, 4, iccLabGridPoints, lab8ToCMYK)},
+		// Everything counts as in gamut, since the CMYK values are computed
+		// rather than printed
+		{"gamt", iccLut8(3, 1, 2, func([]float64) []byte { return []byte{0} })},
+	}
+
+	// The tag data follows the header and the tag table, each tag aligned to
+	// 4 bytes
+	offset := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		table.WriteString(tag.sig)
+		binary.Write(&table, binary.BigEndian, [2]uint32{uint32(offset + data.Len()), uint32(len(tag.data))})
+		data.Write(tag.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	size := offset + data.Len()
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "prtr")
+	copy(header[16:], "CMYK")
+	copy(header[20:], "Lab ")
+	// A fixed creation date keeps the PDFs of the same pages identical
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], iccXYZ(d50)[8:])
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header...)
+	profile = append(profile, table.Bytes()...)
+	return append(profile, data.Bytes()...)
+}
+
+// iccTextDescription encodes a textDescriptionType with an ASCII description
+// and empty Unicode and ScriptCode descriptions
+func iccTextDescription(s string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(&buf, binary.BigEndian, uint32(len(s)+1))
+	buf.WriteString(s + "\x00")
+	buf.Write(make([]byte, 4+4+2+1+67))
+	return buf.Bytes()
+}
+
+// iccText encodes a textType
+func iccText(s string) []byte {
+	return []byte("text\x00\x00\x00\x00" + s + "\x00")
+}
+
+// iccXYZ encodes an XYZType with one value
+func iccXYZ(xyz [3]float64) []byte {
+	buf := []byte("XYZ \x00\x00\x00\x00")
+	for _, v := range xyz {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(int32(math.Round(v*65536))))
+	}
+	return buf
+}
+
+// iccLut8 encodes a lut8Type with identity input and output curves and a
+// color lookup table with grid points per input channel, sampled by f. f
+// gets the input values scaled to 0-1 and returns the 8-bit outputs.
+func iccLut8(in, out, grid int, f func([]float64) []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("mft1\x00\x00\x00\x00")
+	buf.Write([]byte{byte(in), byte(out), byte(grid), 0})
+	// The matrix only applies to XYZ input, but must be present
+	for i := range 9 {
+		v := uint32(0)
+		if i%4 == 0 {
+			v = 1 << 16
+		}
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	identity := make([]byte, 256)
+	for i := range identity {
+		identity[i] = byte(i)
+	}
+	for range in {
+		buf.Write(identity)
+	}
+
+	// The first input channel varies slowest
+	index := make([]int, in)
+	values := make([]float64, in)
+	for {
+		for i, n := range index {
+			values[i] = float64(n) / float64(grid-1)
+		}
+		buf.Write(f(values))
+
+		i := in - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < grid {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+
+	for range out {
+		buf.Write(identity)
+	}
+	return buf.Bytes()
+}
+
+// cmykToLab8 converts CMYK values scaled to 0-1 to 8-bit encoded CIELAB the
+// way color.CMYK converts to RGB
+func cmykToLab8(cmyk []float64) []byte {
+	c := color.CMYK{C: to8(cmyk[0]), M: to8(cmyk[1]), Y: to8(cmyk[2]), K: to8(cmyk[3])}
+	r, g, b, _ := c.RGBA()
+	l, a, bb := srgbToLab(float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+	return []byte{to8(l / 100), to8((a + 128) / 255), to8((bb + 128) / 255)}
+}
+
+// lab8ToCMYK converts 8-bit encoded CIELAB scaled to 0-1 to CMYK with
+// color.CMYKModel, as the pages are converted
+func lab8ToCMYK(lab []float64) []byte {
+	r, g, b := labToSRGB(lab[0]*100, lab[1]*255-128, lab[2]*255-128)
+	c := color.CMYKModel.Convert(color.RGBA{R: to8(r), G: to8(g), B: to8(b), A: 0xff}).(color.CMYK)
+	return []byte{c.C, c.M, c.Y, c.K}
+}
+
+// to8 scales v from 0-1 to 0-255, clipping values outside the range
+func to8(v float64) uint8 {
+	return uint8(math.Round(min(max(v, 0), 1) * 255))
+}
+
+// srgbToXYZ is the sRGB matrix adapted to the D50 white point, from the
+// sRGB ICC profile
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// xyzToSRGB is the inverse of srgbToXYZ
+var xyzToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbToLab converts sRGB values in 0-1 to CIELAB relative to D50
+func srgbToLab(r, g, b float64) (l, a, bb float64) {
+	linear := [3]float64{srgbDecode(r), srgbDecode(g), srgbDecode(b)}
+	var f [3]float64
+	for i, row := range srgbToXYZ {
+		f[i] = labF((row[0]*linear[0] + row[1]*linear[1] + row[2]*linear[2]) / d50[i])
+	}
+	return 116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])
+}
+
+// labToSRGB converts CIELAB relative to D50 to sRGB values in 0-1, which may
+// lie outside the range for colors sRGB cannot show
+func labToSRGB(l, a, bb float64) (r, g, b float64) {
+	fy := (l + 16) / 116
+	xyz := [3]float64{labFInverse(fy+a/500) * d50[0], labFInverse(fy) * d50[1], labFInverse(fy-bb/200) * d50[2]}
+	var rgb [3]float64
+	for i, row := range xyzToSRGB {
+		rgb[i] = srgbEncode(row[0]*xyz[0] + row[1]*xyz[1] + row[2]*xyz[2])
+	}
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// labF is the cube root function of CIELAB with its linear part near zero
+func labF(t float64) float64 {
+	const delta = 6.0 / 29
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29
+}
+
+// labFInverse is the inverse of labF
+func labFInverse(t float64) float64 {
+	const delta = 6.0 / 29
+	if t > delta {
+		return t * t * t
+	}
+	return 3 * delta * delta * (t - 4.0/29)
+}
+
+// srgbDecode converts a gamma-encoded sRGB value to linear light
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// srgbEncode converts linear light to a gamma-encoded sRGB value
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
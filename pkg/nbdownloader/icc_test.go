@@ -0,0 +1,86 @@
+package nbdownloader
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+func TestCMYKProfileLayout(t *testing.T) {
+	profile := cmykProfile()
+	if size := binary.BigEndian.Uint32(profile); int(size) != len(profile) {
+		t.Fatalf("header size %d, profile has %d bytes", size, len(profile))
+	}
+	for offset, want := range map[int]string{12: "prtr", 16: "CMYK", 20: "Lab ", 36: "acsp"} {
+		if got := string(profile[offset : offset+4]); got != want {
+			t.Errorf("header at %d = %q, want %q", offset, got, want)
+		}
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	tags := make(map[string]bool)
+	for i := range count {
+		entry := profile[132+12*i:]
+		sig := string(entry[:4])
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Errorf("tag %s at %d with %d bytes is misaligned or beyond the profile", sig, offset, size)
+		}
+		tags[sig] = true
+	}
+	// The tags required in an output profile
+	for _, sig := range []string{"desc", "cprt", "wtpt", "A2B0", "B2A0", "gamt"} {
+		if !tags[sig] {
+			t.Errorf("profile lacks the %s tag", sig)
+		}
+	}
+}
+
+func TestLab8ToCMYK(t *testing.T) {
+	tests := []struct {
+		name string
+		lab  []float64
+		want []byte
+	}{
+		{"white", []float64{1, 128.0 / 255, 128.0 / 255}, []byte{0, 0, 0, 0}},
+		{"black", []float64{0, 128.0 / 255, 128.0 / 255}, []byte{0, 0, 0, 255}},
+	}
+	for _, tt := range tests {
+		if got := lab8ToCMYK(tt.lab); !closeBytes(got, tt.want, 1) {
+			t.Errorf("lab8ToCMYK(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCMYKProfileRoundTrip(t *testing.T) {
+	for _, rgb := range []color.RGBA{
+		{255, 255, 255, 255},
+		{0, 0, 0, 255},
+		{200, 30, 40, 255},
+		{30, 120, 200, 255},
+		{240, 230, 200, 255}, // yellowed paper
+		{90, 90, 90, 255},
+	} {
+		c, m, y, k := color.RGBToCMYK(rgb.R, rgb.G, rgb.B)
+		cmyk := []byte{c, m, y, k}
+		lab := cmykToLab8([]float64{float64(c) / 255, float64(m) / 255, float64(y) / 255, float64(k) / 255})
+		got := lab8ToCMYK([]float64{float64(lab[0]) / 255, float64(lab[1]) / 255, float64(lab[2]) / 255})
+		// 8-bit Lab is coarser than 8-bit CMYK
+		if !closeBytes(got, cmyk, 8) {
+			t.Errorf("CMYK %v of %v came back as %v through Lab %v", cmyk, rgb, got, lab)
+		}
+	}
+}
+
+// closeBytes reports whether a and b differ by at most tolerance in each byte
+func closeBytes(a, b []byte, tolerance int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if d := int(a[i]) - int(b[i]); d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	return true
+}
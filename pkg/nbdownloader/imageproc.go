@@ -0,0 +1,573 @@
+package nbdownloader
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jpegQuality is used when re-encoding processed page images
+const jpegQuality = 90
+
+// ValidateColorSpace checks that space is a supported color space
+func ValidateColorSpace(space string) error {
+	switch space {
+	case "rgb", "gray", "cmyk":
+		return nil
+	}
+	return fmt.Errorf("unknown color space %q, expected 'rgb', 'gray' or 'cmyk'", space)
+}
+
+// ValidateImageFormat checks that format is an image format the pages can be
+// requested in
+func ValidateImageFormat(format string) error {
+	switch format {
+	case "jpg", "png":
+		return nil
+	}
+	return fmt.Errorf("unknown image format %q, expected 'jpg' or 'png'", format)
+}
+
+// processImage applies the selected image filters to a downloaded page in
+// place. Pages are only decoded and re-encoded if a filter is active or the
+// page needs rotating according to its EXIF orientation.
+func (b *Book) processImage(path, pageNr string) error {
+	side := ""
+	if b.spineShadow {
+		side = spineSide(pageNr)
+	}
+	filters := b.colorSpace == "gray" || side != "" || b.whiteBackground || b.denoise || b.sharpen || b.binarize || b.autoMargin || b.padding > 0
+	if !filters {
+		if b.stripEXIF {
+			return stripEXIF(path)
+		}
+		if orientation, _ := readEXIFOrientation(path); orientation == 1 {
+			return nil
+		}
+	}
+
+	// The re-encoded JPEG carries no EXIF data, so the rotation is applied once
+	img := correctOrientation(path)
+	if img == nil {
+		return fmt.Errorf("error decoding %s", path)
+	}
+
+	if side != "" {
+		img = removeSpineShadow(img, side)
+	}
+	if b.whiteBackground {
+		img = normalizeBackground(img)
+	}
+	if b.denoise {
+		img = denoiseImage(img, b.denoiseRadius)
+	}
+	if b.sharpen {
+		img = sharpenImage(img, b.sharpenAmount, b.sharpenRadius, b.sharpenThreshold)
+	}
+	if b.binarize {
+		img = binarizeAdaptive(img, b.binarizeWindow, b.binarizeK)
+	}
+	if b.autoMargin {
+		img = normalizeMargins(img, b.margin)
+	}
+	if b.padding > 0 {
+		img = addPadding(img, b.padding, b.paddingColor)
+	}
+	if b.colorSpace == "gray" {
+		img = toGray(img)
+	}
+
+	return saveImage(path, img)
+}
+
+// stripEXIF re-encodes a JPEG without its EXIF metadata. image/jpeg does not
+// apply the EXIF orientation when decoding, so it is applied here before the
+// tag is lost.
+func stripEXIF(imgPath string) error {
+	img := correctOrientation(imgPath)
+	if img == nil {
+		return fmt.Errorf("error decoding %s", imgPath)
+	}
+	return saveImage(imgPath, img)
+}
+
+// loadImage decodes a JPEG or PNG file
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// saveJPEG encodes an image as a JPEG file. An existing file is removed
+// first rather than overwritten, as it may be a hard link into the page
+// cache whose original must not change.
+func saveJPEG(path string, img image.Image) error {
+	os.Remove(path)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		f.Close()
+		return fmt.Errorf("error encoding %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// saveImage encodes an image as a PNG file if path ends in .png and as a
+// JPEG file otherwise, replacing the file like saveJPEG
+func saveImage(path string, img image.Image) error {
+	if filepath.Ext(path) != ".png" {
+		return saveJPEG(path, img)
+	}
+	os.Remove(path)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("error encoding %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// toGray converts an image to 8-bit grayscale, which is stored as a
+// single-channel JPEG or PNG and embedded in the PDF as DeviceGray
+func toGray(img image.Image) *image.Gray {
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
+
+// spineShadowWidth is the width in pixels of the band along the spine edge
+// that removeSpineShadow brightens
+const spineShadowWidth = 50
+
+// spineSide returns the side of a page that was bound into the spine: odd
+// pages are right-hand pages with the spine on the left, even pages the
+// reverse. The numbered introduction pages are treated the same way. Covers
+// have no spine side and give "".
+func spineSide(pageNr string) string {
+	n, err := strconv.Atoi(strings.TrimPrefix(pageNr, "I"))
+	if err != nil || n < 1 {
+		return ""
+	}
+	if n%2 == 1 {
+		return "left"
+	}
+	return "right"
+}
+
+// removeSpineShadow brightens the shadow that the curvature of the page
+// casts along the spine. Each column within spineShadowWidth pixels of the
+// given side ("left" or "right") is scaled so that its mean brightness
+// matches that of the band of the same width next to it, which evens out the
+// gradient towards the spine. Columns are only ever brightened.
+func removeSpineShadow(img image.Image, side string) image.Image {
+	bounds := img.Bounds()
+	width := min(spineShadowWidth, bounds.Dx()/4)
+	if width < 1 || (side != "left" && side != "right") {
+		return img
+	}
+
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+
+	// column returns the x coordinate of the i-th column from the spine
+	column := func(i int) int {
+		if side == "left" {
+			return bounds.Min.X + i
+		}
+		return bounds.Max.X - 1 - i
+	}
+	brightness := func(x int) float64 {
+		var sum float64
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := dst.PixOffset(x, y)
+			sum += 0.299*float64(dst.Pix[i]) + 0.587*float64(dst.Pix[i+1]) + 0.114*float64(dst.Pix[i+2])
+		}
+		return sum / float64(bounds.Dy())
+	}
+
+	var reference float64
+	for i := width; i < 2*width; i++ {
+		reference += brightness(column(i))
+	}
+	reference /= float64(width)
+
+	for i := range width {
+		x := column(i)
+		mean := brightness(x)
+		if mean <= 0 || mean >= reference {
+			continue
+		}
+		gain := reference / mean
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			p := dst.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				dst.Pix[p+c] = uint8(math.Round(min(float64(dst.Pix[p+c])*gain, 255)))
+			}
+		}
+	}
+	return dst
+}
+
+// backgroundSampleFraction is the size of the corner squares sampled for the
+// paper color, as a fraction of the shorter side of the page
+const backgroundSampleFraction = 0.05
+
+// minBackground is the darkest sampled paper brightness that is corrected.
+// Darker corners are most likely scanner borders or pictures, and scaling
+// them to white would wash out the whole page.
+const minBackground = 128
+
+// normalizeBackground turns yellowed or off-white paper white. The paper
+// color is the average of small squares in the four corners of the page,
+// where there is rarely any print. Each color channel is then scaled so that
+// the paper color becomes white while black stays black, which keeps the
+// contrast of the print.
+func normalizeBackground(img image.Image) image.Image {
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+
+	size := max(int(float64(min(bounds.Dx(), bounds.Dy()))*backgroundSampleFraction), 1)
+	corners := []image.Rectangle{
+		image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+size, bounds.Min.Y+size),
+		image.Rect(bounds.Max.X-size, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+size),
+		image.Rect(bounds.Min.X, bounds.Max.Y-size, bounds.Min.X+size, bounds.Max.Y),
+		image.Rect(bounds.Max.X-size, bounds.Max.Y-size, bounds.Max.X, bounds.Max.Y),
+	}
+	var sum [3]float64
+	var n int
+	for _, corner := range corners {
+		corner = corner.Intersect(bounds)
+		for y := corner.Min.Y; y < corner.Max.Y; y++ {
+			for x := corner.Min.X; x < corner.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				for c := range sum {
+					sum[c] += float64(src.Pix[i+c])
+				}
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return img
+	}
+
+	var gain [3]float64
+	for c := range gain {
+		background := sum[c] / float64(n)
+		if background < minBackground {
+			return img
+		}
+		gain[c] = 255 / background
+	}
+
+	for i := 0; i < len(src.Pix); i += 4 {
+		for c := range gain {
+			src.Pix[i+c] = uint8(math.Round(min(float64(src.Pix[i+c])*gain[c], 255)))
+		}
+	}
+	return src
+}
+
+// denoiseImage applies a median filter with a (2*radius+1)² window to each
+// color channel. Unlike a blur it removes speckles and scanner dust while
+// keeping the edges of the text sharp. Pixels near the border use the part
+// of the window that lies inside the image.
+func denoiseImage(img image.Image, radius int) image.Image {
+	if radius < 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(bounds)
+
+	window := make([][]uint8, 3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for c := range window {
+				window[c] = window[c][:0]
+			}
+			for wy := max(y-radius, bounds.Min.Y); wy <= min(y+radius, bounds.Max.Y-1); wy++ {
+				for wx := max(x-radius, bounds.Min.X); wx <= min(x+radius, bounds.Max.X-1); wx++ {
+					i := src.PixOffset(wx, wy)
+					for c := range window {
+						window[c] = append(window[c], src.Pix[i+c])
+					}
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			for c := range window {
+				slices.Sort(window[c])
+				dst.Pix[i+c] = window[c][len(window[c])/2]
+			}
+			dst.Pix[i+3] = src.Pix[src.PixOffset(x, y)+3]
+		}
+	}
+	return dst
+}
+
+// sharpenImage applies an unsharp mask: the difference between the image and
+// a Gaussian blur of the given radius (its standard deviation in pixels) is
+// scaled by amount and added back. Differences smaller than threshold, on the
+// 0-255 scale, are left alone so that paper grain is not amplified.
+func sharpenImage(img image.Image, amount, radius, threshold float64) image.Image {
+	if amount <= 0 || radius <= 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+	blurred := gaussianBlur(src, gaussianKernel(radius))
+
+	dst := image.NewRGBA(bounds)
+	for i := 0; i < len(src.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			orig := float64(src.Pix[i+c])
+			diff := orig - blurred[i+c]
+			if math.Abs(diff) < threshold {
+				dst.Pix[i+c] = src.Pix[i+c]
+				continue
+			}
+			dst.Pix[i+c] = uint8(math.Round(min(max(orig+amount*diff, 0), 255)))
+		}
+		dst.Pix[i+3] = src.Pix[i+3]
+	}
+	return dst
+}
+
+// gaussianKernel returns a normalized 1D Gaussian kernel with standard
+// deviation sigma, covering three standard deviations on either side
+func gaussianKernel(sigma float64) []float64 {
+	r := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*r+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - r)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// gaussianBlur convolves the image with kernel horizontally and then
+// vertically, repeating the edge pixels beyond the border. The result has
+// the layout of img.Pix with one float per channel.
+func gaussianBlur(img *image.RGBA, kernel []float64) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	r := len(kernel) / 2
+
+	horizontal := make([]float64, len(img.Pix))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 4; c++ {
+				var sum float64
+				for k, weight := range kernel {
+					sx := min(max(x+k-r, 0), w-1)
+					sum += weight * float64(img.Pix[y*img.Stride+sx*4+c])
+				}
+				horizontal[y*img.Stride+x*4+c] = sum
+			}
+		}
+	}
+
+	blurred := make([]float64, len(img.Pix))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 4; c++ {
+				var sum float64
+				for k, weight := range kernel {
+					sy := min(max(y+k-r, 0), h-1)
+					sum += weight * horizontal[sy*img.Stride+x*4+c]
+				}
+				blurred[y*img.Stride+x*4+c] = sum
+			}
+		}
+	}
+	return blurred
+}
+
+// sauvolaRange is the dynamic range R of the standard deviation in Sauvola's
+// formula for 8-bit images
+const sauvolaRange = 128
+
+// binarizeAdaptive converts an image to black and white with Sauvola's
+// adaptive threshold. Each pixel is compared with
+//
+//	T = m * (1 + k*(s/R - 1))
+//
+// where m and s are the mean and standard deviation of the gray values in
+// the windowSize×windowSize window around it. Unlike a global threshold this
+// copes with stained or unevenly lit parchment; a larger k makes the result
+// lighter.
+func binarizeAdaptive(img image.Image, windowSize int, k float64) image.Image {
+	gray := toGray(img)
+	bounds := gray.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Integral images of the gray values and their squares give the window
+	// sums in constant time
+	stride := w + 1
+	sum := make([]float64, stride*(h+1))
+	sumSq := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSumSq float64
+		for x := 0; x < w; x++ {
+			v := float64(gray.Pix[y*gray.Stride+x])
+			rowSum += v
+			rowSumSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sumSq[(y+1)*stride+x+1] = sumSq[y*stride+x+1] + rowSumSq
+		}
+	}
+
+	half := max(windowSize/2, 1)
+	out := image.NewGray(bounds)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, w)
+			n := float64((x1 - x0) * (y1 - y0))
+			s := sum[y1*stride+x1] - sum[y0*stride+x1] - sum[y1*stride+x0] + sum[y0*stride+x0]
+			sq := sumSq[y1*stride+x1] - sumSq[y0*stride+x1] - sumSq[y1*stride+x0] + sumSq[y0*stride+x0]
+			mean := s / n
+			stddev := math.Sqrt(max(sq/n-mean*mean, 0))
+			threshold := mean * (1 + k*(stddev/sauvolaRange-1))
+
+			if float64(gray.Pix[y*gray.Stride+x]) > threshold {
+				out.Pix[y*out.Stride+x] = 255
+			}
+		}
+	}
+	return out
+}
+
+// addPadding surrounds an image with a border of paddingPx pixels in the
+// given color, so that content reaching the edge of the scan is not cut off
+// by PDF viewers
+func addPadding(img image.Image, paddingPx int, fill color.Color) image.Image {
+	bounds := img.Bounds()
+	padded := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+2*paddingPx, bounds.Dy()+2*paddingPx))
+	draw.Draw(padded, padded.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	inner := image.Rect(paddingPx, paddingPx, paddingPx+bounds.Dx(), paddingPx+bounds.Dy())
+	draw.Draw(padded, inner, img, bounds.Min, draw.Src)
+	return padded
+}
+
+// ParseHexColor parses a color in #RRGGBB notation
+func ParseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #RRGGBB", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, expected #RRGGBB", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// textThreshold is the gray value below which a pixel counts as text
+const textThreshold = 128
+
+// pageWidthMM is the width of a page in the PDF, which is A4
+const pageWidthMM = 210
+
+// normalizeMargins crops a page to its text area and surrounds it with a
+// white margin of marginMM millimetres, so that inconsistently cropped scans
+// end up with the same margins. The page is assumed to be as wide as an A4
+// page in the PDF. Blank pages are left unchanged.
+func normalizeMargins(img image.Image, marginMM float64) image.Image {
+	text := detectTextBoundingBox(img, textThreshold)
+	if text.Empty() {
+		return img
+	}
+
+	pxPerMM := float64(img.Bounds().Dx()) / pageWidthMM
+	margin := int(math.Round(marginMM * pxPerMM))
+
+	cropped := image.NewRGBA(image.Rect(0, 0, text.Dx(), text.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, text.Min, draw.Src)
+	return addPadding(cropped, margin, color.White)
+}
+
+// detectTextBoundingBox returns the smallest rectangle containing every pixel
+// darker than threshold, or an empty rectangle if there is none. Rows are
+// scanned inwards from the top and bottom edges, then columns from the left
+// and right edges within the rows found, so that the scan stops at the first
+// dark pixel on each side.
+func detectTextBoundingBox(img image.Image, threshold uint8) image.Rectangle {
+	gray := toGray(img)
+	bounds := gray.Bounds()
+
+	dark := func(x, y int) bool {
+		return gray.GrayAt(x, y).Y < threshold
+	}
+	rowHasText := func(y int) bool {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if dark(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+
+	top := bounds.Min.Y
+	for top < bounds.Max.Y && !rowHasText(top) {
+		top++
+	}
+	if top == bounds.Max.Y {
+		return image.Rectangle{}
+	}
+	bottom := bounds.Max.Y - 1
+	for !rowHasText(bottom) {
+		bottom--
+	}
+
+	colHasText := func(x int) bool {
+		for y := top; y <= bottom; y++ {
+			if dark(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+	left := bounds.Min.X
+	for !colHasText(left) {
+		left++
+	}
+	right := bounds.Max.X - 1
+	for !colHasText(right) {
+		right--
+	}
+
+	return image.Rect(left, top, right+1, bottom+1)
+}
@@ -0,0 +1,215 @@
+package nbdownloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// manifestURLTemplate points at the IIIF Presentation manifest for a document
+const manifestURLTemplate = "{api_base_url}/iiif/{urn}/manifest"
+
+// manifestURL returns the URL of the IIIF manifest of the document with the
+// URN urn, see documentURN, in the catalog API at apiBaseURL
+func manifestURL(apiBaseURL, urn string) string {
+	u := strings.Replace(manifestURLTemplate, "{api_base_url}", apiBaseURL, 1)
+	return strings.Replace(u, "{urn}", urn, 1)
+}
+
+// IIIFManifest holds the parts of a IIIF Presentation manifest used by the
+// downloader
+type IIIFManifest struct {
+	Label     json.RawMessage     `json:"label"`
+	Metadata  []IIIFMetadataEntry `json:"metadata"`
+	License   json.RawMessage     `json:"license"`
+	Sequences []IIIFSequence      `json:"sequences"`
+}
+
+// IIIFMetadataEntry is a label/value pair from the manifest metadata list
+type IIIFMetadataEntry struct {
+	Label json.RawMessage `json:"label"`
+	Value json.RawMessage `json:"value"`
+}
+
+// IIIFSequence is an ordering of the pages of a document
+type IIIFSequence struct {
+	Canvases []IIIFCanvas `json:"canvases"`
+}
+
+// IIIFCanvas is a page of a document
+type IIIFCanvas struct {
+	ID     string          `json:"@id"`
+	Label  json.RawMessage `json:"label"`
+	Width  int             `json:"width"`  // full size in pixels
+	Height int             `json:"height"` // full size in pixels
+}
+
+// PageID returns the page identifier the canvas ID ends in, e.g. "C1",
+// "I2" or "42" for ..._C1, ..._I2 and ..._0042
+func (c IIIFCanvas) PageID() string {
+	pageID := c.ID[strings.LastIndex(c.ID, "_")+1:]
+	if n, err := strconv.Atoi(pageID); err == nil {
+		return strconv.Itoa(n)
+	}
+	return pageID
+}
+
+// PageLabel returns the label of the canvas, e.g. "Side 7" or "Omslag"
+func (c IIIFCanvas) PageLabel() string {
+	return iiifString(c.Label)
+}
+
+// Canvases returns the pages of the first sequence, the reading order
+func (m *IIIFManifest) Canvases() []IIIFCanvas {
+	if len(m.Sequences) == 0 {
+		return nil
+	}
+	return m.Sequences[0].Canvases
+}
+
+// Length returns the number of numbered pages
+func (m *IIIFManifest) Length() int {
+	length := 0
+	for _, canvas := range m.Canvases() {
+		if isNumberedPage(canvas.PageID()) {
+			length++
+		}
+	}
+	return length
+}
+
+// layout returns the unnumbered pages listed before and after the numbered
+// ones. Unnumbered pages between numbered ones are left out, as the
+// downloader has no place for them.
+func (m *IIIFManifest) layout() pageLayout {
+	var layout pageLayout
+	numbered := false
+	for _, canvas := range m.Canvases() {
+		pageID := canvas.PageID()
+		switch {
+		case isNumberedPage(pageID):
+			numbered = true
+			layout.back = nil
+		case !numbered:
+			layout.front = append(layout.front, pageID)
+		default:
+			layout.back = append(layout.back, pageID)
+		}
+	}
+	return layout
+}
+
+// isNumberedPage reports whether pageID is a numbered page rather than a
+// cover or introduction page
+func isNumberedPage(pageID string) bool {
+	_, err := strconv.Atoi(pageID)
+	return err == nil
+}
+
+// FetchIIIFManifest downloads and parses the IIIF manifest of a document
+// from the catalog API at apiBaseURL, or from nb.no if it is empty, e.g.
+// FetchIIIFManifest(ctx, http.DefaultClient, "", "2008011100001", "digibok").
+// The request is sent through client the way a Book sends it, so the
+// cookies of client's jar are sent with it and documents that need a login
+// can be read.
+func FetchIIIFManifest(ctx context.Context, client *http.Client, apiBaseURL, id, docType string) (*IIIFManifest, error) {
+	b := NewBook(id, DownloadOptions{DocumentType: docType, APIBaseURL: apiBaseURL})
+	b.client = client
+	return b.fetchManifest(ctx)
+}
+
+// decodeManifest parses the IIIF manifest in the body of resp and closes it
+func decodeManifest(resp *http.Response) (*IIIFManifest, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching manifest: HTTP Status %d", resp.StatusCode)
+	}
+
+	var manifest IIIFManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// fetchManifest downloads and parses the book's IIIF manifest. The result
+// is kept, as the length, the page layout and the metadata all come from
+// it, and a missing manifest shouldn't be asked for again each time.
+func (b *Book) fetchManifest(ctx context.Context) (*IIIFManifest, error) {
+	b.mu.RLock()
+	manifest, err := b.manifest, b.manifestErr
+	b.mu.RUnlock()
+	if manifest != nil || err != nil {
+		return manifest, err
+	}
+
+	resp, err := b.get(ctx, http.MethodGet, manifestURL(b.apiBaseURL, b.urn()))
+	if err != nil {
+		err = fmt.Errorf("error fetching manifest: %w", err)
+	} else {
+		manifest, err = decodeManifest(resp)
+	}
+	b.mu.Lock()
+	b.manifest = manifest
+	// A cancelled request says nothing about the manifest
+	if ctx.Err() == nil {
+		b.manifestErr = err
+	}
+	b.mu.Unlock()
+	return manifest, err
+}
+
+// findBookLengthFromManifest counts the numbered pages listed in the IIIF manifest
+func (b *Book) findBookLengthFromManifest(ctx context.Context) (int, error) {
+	manifest, err := b.fetchManifest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(manifest.Sequences) == 0 {
+		return 0, fmt.Errorf("manifest contains no sequences")
+	}
+	return manifest.Length(), nil
+}
+
+// iiifStrings flattens a IIIF property value, which may be a plain string,
+// a language-tagged {"@value": ...} object, or a list of either
+func iiifStrings(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}
+	}
+
+	var tagged struct {
+		Value string `json:"@value"`
+	}
+	if err := json.Unmarshal(raw, &tagged); err == nil && tagged.Value != "" {
+		return []string{tagged.Value}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		var values []string
+		for _, item := range list {
+			values = append(values, iiifStrings(item)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// iiifString returns the first string of a IIIF property value
+func iiifString(raw json.RawMessage) string {
+	values := iiifStrings(raw)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,39 @@
+package nbdownloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchIIIFManifest(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"sequences": [{"canvases": [
+			{"@id": "URN:NBN:no-nb_digibok_2008011100001_C1"},
+			{"@id": "URN:NBN:no-nb_digibok_2008011100001_0001"},
+			{"@id": "URN:NBN:no-nb_digibok_2008011100001_0002"},
+			{"@id": "URN:NBN:no-nb_digibok_2008011100001_C3"}
+		]}]}`))
+	}))
+	defer server.Close()
+
+	manifest, err := FetchIIIFManifest(context.Background(), server.Client(), server.URL, "2008011100001", "digibok")
+	if err != nil {
+		t.Fatalf("FetchIIIFManifest() = %v", err)
+	}
+	if want := "/iiif/URN:NBN:no-nb_digibok_2008011100001/manifest"; path != want {
+		t.Errorf("requested %s, want %s", path, want)
+	}
+	if n := manifest.Length(); n != 2 {
+		t.Errorf("Length() = %d, want 2", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FetchIIIFManifest(ctx, server.Client(), server.URL, "2008011100001", "digibok"); err == nil {
+		t.Error("FetchIIIFManifest() with a cancelled context succeeded")
+	}
+}
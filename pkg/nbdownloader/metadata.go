@@ -0,0 +1,109 @@
+package nbdownloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Metadata describes a book as listed in the nb.no IIIF manifest
+type Metadata struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	URN       string            `json:"urn"`
+	Title     string            `json:"title"`
+	Authors   []string          `json:"authors,omitempty"`
+	Publisher string            `json:"publisher,omitempty"`
+	Year      string            `json:"year,omitempty"`
+	Language  string            `json:"language,omitempty"`
+	Rights    string            `json:"rights,omitempty"` // license or terms of use
+	Fields    map[string]string `json:"fields,omitempty"` // every label/value pair from the manifest
+}
+
+// documentURN returns the URN identifying a document at nb.no. A
+// newspaper or periodical issue is identified by the title ID and the issue
+// date, which its page URLs are built from as well.
+func documentURN(id, docType, issueDate string) string {
+	urn := "URN:NBN:no-nb_" + docType + "_" + id
+	if issueDate != "" {
+		urn += "_" + issueDate
+	}
+	return urn
+}
+
+// urn returns the URN identifying the book at nb.no
+func (b *Book) urn() string {
+	return documentURN(b.id, b.documentType, b.issueDate)
+}
+
+// FetchMetadata reads the book's metadata from its IIIF manifest
+func (b *Book) FetchMetadata(ctx context.Context) (*Metadata, error) {
+	manifest, err := b.fetchManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{
+		ID:     b.id,
+		Type:   b.documentType,
+		URN:    b.urn(),
+		Title:  iiifString(manifest.Label),
+		Rights: iiifString(manifest.License),
+		Fields: make(map[string]string),
+	}
+
+	// nb.no labels its metadata in either English or Norwegian
+	for _, entry := range manifest.Metadata {
+		label := iiifString(entry.Label)
+		values := iiifStrings(entry.Value)
+		if label == "" || len(values) == 0 {
+			continue
+		}
+		meta.Fields[label] = strings.Join(values, "; ")
+
+		switch strings.ToLower(label) {
+		case "title", "tittel":
+			if meta.Title == "" {
+				meta.Title = values[0]
+			}
+		case "creator", "author", "forfatter", "opphav":
+			meta.Authors = append(meta.Authors, values...)
+		case "publisher", "utgiver", "forlag":
+			meta.Publisher = values[0]
+		case "date", "year", "published", "utgitt", "utgivelsesår":
+			meta.Year = values[0]
+		case "language", "språk":
+			meta.Language = values[0]
+		case "rights", "rettigheter", "license", "lisens":
+			if meta.Rights == "" {
+				meta.Rights = values[0]
+			}
+		}
+	}
+
+	if meta.Title == "" {
+		meta.Title = b.id
+	}
+	return meta, nil
+}
+
+// Metadata returns the book's metadata, fetching it on first use. If the
+// manifest cannot be read, the book ID is used as the title.
+func (b *Book) Metadata(ctx context.Context) *Metadata {
+	b.mu.RLock()
+	meta := b.metadata
+	b.mu.RUnlock()
+	if meta != nil {
+		return meta
+	}
+
+	meta, err := b.FetchMetadata(ctx)
+	if err != nil {
+		fmt.Fprintln(b.log, "Could not fetch metadata, using book ID as title:", err)
+		meta = &Metadata{ID: b.id, Type: b.documentType, URN: b.urn(), Title: b.id}
+	}
+	b.mu.Lock()
+	b.metadata = meta
+	b.mu.Unlock()
+	return meta
+}
@@ -0,0 +1,165 @@
+package nbdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// DefaultNERModel is the spaCy model used for named entity recognition
+// unless another is given: the small Norwegian bokmål pipeline
+const DefaultNERModel = "nb_core_news_sm"
+
+// spacyScript loads the spaCy model given as its argument, reads one JSON
+// string per line from stdin and writes the entities of each as a JSON array
+// of [text, label] pairs per line. Without input it only loads the model.
+const spacyScript = `
+import json, sys
+import spacy
+nlp = spacy.load(sys.argv[1])
+texts = [json.loads(line) for line in sys.stdin]
+for doc in nlp.pipe(texts):
+    print(json.dumps([[e.text, e.label_] for e in doc.ents]))
+`
+
+// entityTypes maps the spaCy entity labels of interest to the types saved.
+// The Norwegian models label places and organizations that are also
+// political entities GPE_LOC and GPE_ORG.
+var entityTypes = map[string]string{
+	"PER":     "person",
+	"PERSON":  "person",
+	"LOC":     "place",
+	"GPE_LOC": "place",
+	"GPE":     "place",
+	"ORG":     "organization",
+	"GPE_ORG": "organization",
+}
+
+// entity is a name found in the text of a book
+type entity struct {
+	Text  string   `json:"text"`
+	Type  string   `json:"type"` // "person", "place" or "organization"
+	Count int      `json:"count"`
+	Pages []string `json:"pages"` // pages the name occurs on, printed page numbers if known
+}
+
+// findPython returns the Python interpreter that runs spaCy: $SPACY_PYTHON
+// if set, e.g. the interpreter of a virtual environment, otherwise python3
+// or python in PATH
+func findPython() (string, error) {
+	if path := os.Getenv("SPACY_PYTHON"); path != "" {
+		return path, nil
+	}
+	for _, name := range []string{"python3", "python"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no python3 found in PATH, set SPACY_PYTHON to the Python interpreter with spaCy installed")
+}
+
+// runSpacy runs spacyScript with the given input
+func (b *Book) runSpacy(ctx context.Context, python string, input []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, python, "-c", spacyScript, b.nerModel)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// The last line of a Python traceback names the error
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		return nil, fmt.Errorf("spaCy failed: %w: %s", err, lines[len(lines)-1])
+	}
+	return out, nil
+}
+
+// checkSpacy makes sure before the download that spaCy and the model can be
+// loaded, and returns the Python interpreter
+func (b *Book) checkSpacy(ctx context.Context) (string, error) {
+	python, err := findPython()
+	if err != nil {
+		return "", err
+	}
+	if _, err := b.runSpacy(ctx, python, nil); err != nil {
+		return "", fmt.Errorf("%w (install spaCy and the model with 'pip install spacy && python -m spacy download %s')", err, b.nerModel)
+	}
+	return python, nil
+}
+
+// saveEntities finds the people, places and organizations named in the
+// page texts and saves them as <name>_entities.json, most frequent first
+// within each type. It returns the path of the file.
+func (d *download) saveEntities(ctx context.Context, python string, pageIDs, texts []string) (string, error) {
+	var input bytes.Buffer
+	for _, text := range texts {
+		// Line breaks within paragraphs would split names across lines
+		line, err := json.Marshal(strings.Join(strings.Fields(text), " "))
+		if err != nil {
+			return "", err
+		}
+		input.Write(append(line, '\n'))
+	}
+	fmt.Fprintln(d.log, "Finding named entities with", d.nerModel)
+	out, err := d.runSpacy(ctx, python, input.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	entities := []*entity{}
+	found := make(map[string]*entity)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 16*1024*1024)
+	for i := 0; scanner.Scan() && i < len(pageIDs); i++ {
+		var ents [][2]string
+		if err := json.Unmarshal(scanner.Bytes(), &ents); err != nil {
+			return "", fmt.Errorf("unexpected spaCy output: %w", err)
+		}
+		page := cmp.Or(d.pageNumbers[pageIDs[i]], displayPage(pageIDs[i]))
+		for _, ent := range ents {
+			entityType, ok := entityTypes[ent[1]]
+			text := strings.Trim(ent[0], " .,:;-–—\"'«»")
+			if !ok || text == "" {
+				continue
+			}
+			key := entityType + "\x00" + text
+			e := found[key]
+			if e == nil {
+				e = &entity{Text: text, Type: entityType}
+				found[key] = e
+				entities = append(entities, e)
+			}
+			e.Count++
+			if !slices.Contains(e.Pages, page) {
+				e.Pages = append(e.Pages, page)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	slices.SortStableFunc(entities, func(a, b *entity) int {
+		return cmp.Or(strings.Compare(a.Type, b.Type), cmp.Compare(b.Count, a.Count))
+	})
+
+	data, err := json.MarshalIndent(struct {
+		BookID   string    `json:"book_id"`
+		Model    string    `json:"model"`
+		Entities []*entity `json:"entities"`
+	}{d.id, d.nerModel, entities}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	outPath := d.name + "_entities.json"
+	if err := os.WriteFile(outPath, append(data, '\n'), 0644); err != nil {
+		return "", &StorageError{Path: outPath, Err: err}
+	}
+	return outPath, nil
+}
@@ -0,0 +1,149 @@
+package nbdownloader
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// footerBand is the part of the page height at the bottom searched for
+// printed page numbers
+const footerBand = 0.05
+
+// pageNumberRe matches a line holding only a page number, arabic or roman,
+// possibly between dashes as in "- 12 -"
+var pageNumberRe = regexp.MustCompile(`^[-–—\s]*(\d{1,4}|[ivxlcdmIVXLCDM]{1,8})[-–—\s.]*$`)
+
+// romanNumerals lists the roman numeral symbols by decreasing value,
+// including the subtractive pairs
+var romanNumerals = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"}, {100, "c"}, {90, "xc"},
+	{50, "l"}, {40, "xl"}, {10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+}
+
+// formatRoman formats a positive number as a lower-case roman numeral
+func formatRoman(n int) string {
+	var sb strings.Builder
+	for _, r := range romanNumerals {
+		for n >= r.value {
+			sb.WriteString(r.symbol)
+			n -= r.value
+		}
+	}
+	return sb.String()
+}
+
+// parseRoman returns the value of a roman numeral, or 0 if s is not a
+// well-formed one
+func parseRoman(s string) int {
+	s = strings.ToLower(s)
+	n, rest := 0, s
+	for _, r := range romanNumerals {
+		for strings.HasPrefix(rest, r.symbol) {
+			n += r.value
+			rest = rest[len(r.symbol):]
+		}
+	}
+	if rest != "" || n == 0 || formatRoman(n) != s {
+		return 0
+	}
+	return n
+}
+
+// pageNumberCandidate returns the page number printed on a line of its own
+// in OCR text, as an arabic or a roman number; the other is 0
+func pageNumberCandidate(text string) (arabic, roman int) {
+	for _, line := range strings.Split(text, "\n") {
+		m := pageNumberRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n, 0
+		}
+		if n := parseRoman(m[1]); n > 0 {
+			return 0, n
+		}
+	}
+	return 0, 0
+}
+
+// modeOffset returns the most frequent difference between a page's printed
+// number and its position, which is how the numbering relates to the page
+// order. Misread numbers disagree with each other, so at least two pages
+// have to agree.
+func modeOffset(numbers []int) (int, bool) {
+	counts := make(map[int]int)
+	best, bestCount := 0, 0
+	for i, n := range numbers {
+		if n == 0 {
+			continue
+		}
+		offset := n - i
+		counts[offset]++
+		if c := counts[offset]; c > bestCount || c == bestCount && offset < best {
+			best, bestCount = offset, c
+		}
+	}
+	return best, bestCount >= 2
+}
+
+// inferPageNumbers assigns a printed page number to each page from the
+// numbers read on some of them. Arabic numbers are continued over every
+// page from where they reach 1; the pages before get roman numbers if the
+// front matter is numbered that way. Pages that cannot carry a number, such
+// as covers, are skipped. It returns the label of each page, "" for none.
+func inferPageNumbers(arabic, roman []int, numbered []bool) []string {
+	labels := make([]string, len(numbered))
+	arabicOffset, hasArabic := modeOffset(arabic)
+	romanOffset, hasRoman := modeOffset(roman)
+	for i := range labels {
+		if !numbered[i] {
+			continue
+		}
+		if hasArabic && i+arabicOffset >= 1 {
+			labels[i] = strconv.Itoa(i + arabicOffset)
+		} else if hasRoman && i+romanOffset >= 1 {
+			labels[i] = formatRoman(i + romanOffset)
+		}
+	}
+	return labels
+}
+
+// readPageNumbers reads the printed page numbers from the bottom margin of
+// the pages and returns the inferred number of each page by page ID
+func (b *Book) readPageNumbers(ctx context.Context, tesseract string, pages []string) (map[string]string, error) {
+	arabic := make([]int, len(pages))
+	roman := make([]int, len(pages))
+	numbered := make([]bool, len(pages))
+	for i, page := range pages {
+		pageID := pageIDOf(page)
+		numbered[i] = !isCoverPage(pageID)
+		if !numbered[i] {
+			continue
+		}
+
+		fmt.Fprintf(b.log, "\rReading page numbers: %d/%d", i+1, len(pages))
+		text, err := ocrBand(ctx, tesseract, page, "footer", 1-footerBand, 1)
+		if err != nil {
+			fmt.Fprintln(b.log)
+			return nil, fmt.Errorf("error reading page number of %s: %w", pageID, err)
+		}
+		arabic[i], roman[i] = pageNumberCandidate(text)
+	}
+	fmt.Fprintln(b.log)
+
+	numbers := make(map[string]string)
+	for i, label := range inferPageNumbers(arabic, roman, numbered) {
+		if label != "" {
+			numbers[pageIDOf(pages[i])] = label
+		}
+	}
+	fmt.Fprintf(b.log, "Inferred printed page numbers for %d of %d pages\n", len(numbers), len(pages))
+	return numbers, nil
+}
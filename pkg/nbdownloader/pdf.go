@@ -0,0 +1,168 @@
+//go:build !nopdf
+
+package nbdownloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// savePDF combines the page images into a single PDF and returns its path
+func (d *download) savePDF(ctx context.Context, pages []string) (string, error) {
+	fmt.Fprintln(d.log, "Creating PDF...")
+
+	if d.splitSize > 0 {
+		return d.saveSplitPDF(ctx, pages)
+	}
+
+	// Save the PDF
+	outPath := d.TargetPath()
+	if err := d.writePDF(ctx, pages, outPath); err != nil {
+		return "", fmt.Errorf("error saving PDF: %w", err)
+	}
+	d.outFiles = []string{outPath}
+	fmt.Fprintln(d.log, "PDF saved of book", d.id)
+	return outPath, nil
+}
+
+// saveSplitPDF saves the pages as <bookID>_part01.pdf, <bookID>_part02.pdf,
+// etc. with at most b.splitSize pages each and returns the first part's path
+func (d *download) saveSplitPDF(ctx context.Context, pages []string) (string, error) {
+	parts := splitPages(pages, d.splitSize)
+
+	var firstPath string
+	for i, part := range parts {
+		outPath := splitPartPath(d.name, i+1)
+		if err := d.writePDF(ctx, part, outPath); err != nil {
+			return "", fmt.Errorf("error saving PDF: %w", err)
+		}
+		d.outFiles = append(d.outFiles, outPath)
+		if i == 0 {
+			firstPath = outPath
+		}
+	}
+	fmt.Fprintf(d.log, "PDF saved of book %s in %d parts\n", d.id, len(parts))
+	return firstPath, nil
+}
+
+// writePDF writes the page images to a PDF file, streaming one page at a
+// time. The PDF is written to <outPath>.tmp first and renamed when it is
+// complete, so a crash never leaves a truncated file under the final name.
+func (d *download) writePDF(ctx context.Context, pages []string, outPath string) error {
+	meta := d.Metadata(ctx)
+	tmpPath := outPath + ".tmp"
+	if err := writePDFFile(tmpPath, pages, d.compressLevel, d.colorSpace == "cmyk", meta.Title, strings.Join(meta.Authors, "; "), d.bookmarks); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := replaceFile(tmpPath, outPath); err != nil {
+		return err
+	}
+
+	// Reading the page count back catches PDFs that were written but not
+	// completely, e.g. after a failed image. It only warrants a warning since
+	// the pages that did make it are still usable.
+	if err := verifyPDF(outPath, len(pages)); err != nil {
+		fmt.Fprintln(d.log, "Warning: PDF verification failed:", err)
+	}
+	return nil
+}
+
+// writePDFFile writes a PDF with one page per image to path, with its
+// content streams compressed at zlib level, 0 for none, and the pages
+// converted to CMYK if cmyk is set. bookmarks maps image paths to the titles of bookmarks
+// pointing to their pages.
+//
+// The pages are added one after the other. Adding a JPEG page only reads its
+// header and copies the file, so there is no CPU work to spread over
+// goroutines: loading the images ahead in a worker pool made a 500-page book
+// slower (0.7s instead of 0.5s), as each image had to be held in memory. See
+// BenchmarkWritePDF.
+func writePDFFile(path string, pages []string, level int, cmyk bool, title, author string, bookmarks map[string]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	pw := newPDFWriter(f, level)
+	pw.cmyk = cmyk
+	var outline []pdfOutlineItem
+	for _, imgPath := range pages {
+		if err := pw.addPage(imgPath); err != nil {
+			f.Close()
+			return err
+		}
+		if title, ok := bookmarks[imgPath]; ok {
+			outline = append(outline, pdfOutlineItem{title: title, page: pw.pages[len(pw.pages)-1]})
+		}
+	}
+	if err := pw.close(title, author, outline); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// MergePDF combines the pages of books downloaded with Format "none" into a
+// single PDF at outPath, in the order given. A blank page separates the books
+// and each book is bookmarked with its title at its first page. The pages of
+// books with ColorSpace "cmyk" are converted to CMYK. The content streams
+// are compressed at zlib level, or not at all if level is 0.
+func MergePDF(ctx context.Context, outPath string, books []*Book, level int) error {
+	tmpPath := outPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	pw := newPDFWriter(f, level)
+	var outline []pdfOutlineItem
+	var authors []string
+	for i, b := range books {
+		if b.format != "none" {
+			f.Close()
+			return fmt.Errorf("book %s was not downloaded for merging", b.id)
+		}
+		if err := ctx.Err(); err != nil {
+			f.Close()
+			return err
+		}
+		if i > 0 {
+			pw.addBlankPage()
+		}
+		pw.cmyk = b.colorSpace == "cmyk"
+		meta := b.Metadata(ctx)
+		for _, author := range meta.Authors {
+			if !slices.Contains(authors, author) {
+				authors = append(authors, author)
+			}
+		}
+		_, _, pages := b.lastResult()
+		for j, imgPath := range pages {
+			if err := pw.addPage(imgPath); err != nil {
+				f.Close()
+				return err
+			}
+			if j == 0 {
+				outline = append(outline, pdfOutlineItem{title: meta.Title, page: pw.pages[len(pw.pages)-1]})
+			}
+		}
+	}
+	pageCount := len(pw.pages)
+	title := strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath))
+	if err := pw.close(title, strings.Join(authors, "; "), outline); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := replaceFile(tmpPath, outPath); err != nil {
+		return err
+	}
+	return verifyPDF(outPath, pageCount)
+}
@@ -0,0 +1,22 @@
+//go:build nopdf
+
+package nbdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// savePDF leaves the page images in the temporary folder in builds without
+// gofpdf and returns the folder's path
+func (d *download) savePDF(ctx context.Context, pages []string) (string, error) {
+	fmt.Fprintf(d.log, "PDF assembly disabled (built without PDF support). Images saved to %s.\n", d.fullpath)
+	d.outFiles = []string{d.fullpath}
+	return d.fullpath, nil
+}
+
+// MergePDF is not available in builds without PDF support
+func MergePDF(ctx context.Context, outPath string, books []*Book, level int) error {
+	return errors.New("merging is not available, built without PDF support")
+}
@@ -0,0 +1,80 @@
+//go:build !nopdf
+
+package nbdownloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// benchmarkPages writes n copies of a JPEG of noise as wide as the pages
+// from nb.no, which compresses about as badly as a scan, and returns their
+// paths
+func benchmarkPages(b *testing.B, n int) []string {
+	b.Helper()
+	img := image.NewGray(image.Rect(0, 0, DefaultImageWidth, DefaultImageWidth*3/2))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.UintN(256))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	dir := b.TempDir()
+	pages := make([]string, n)
+	for i := range pages {
+		pages[i] = filepath.Join(dir, fmt.Sprintf("%d.jpg", i+1))
+		if err := os.WriteFile(pages[i], buf.Bytes(), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return pages
+}
+
+// BenchmarkWritePDF writes a 500-page PDF. Adding a JPEG page copies the
+// file, so this mostly measures I/O.
+func BenchmarkWritePDF(b *testing.B) {
+	pages := benchmarkPages(b, 500)
+	path := filepath.Join(b.TempDir(), "book.pdf")
+	b.ResetTimer()
+	for range b.N {
+		if err := writePDFFile(path, pages, 1, false, "Title", "Author", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWritePDFHeapInuse reports runtime.MemStats.HeapInuse before and
+// after writing a 200-page PDF. pdfWriter streams the pages to the file, so
+// the heap stays far below the size of the page images, unlike with gofpdf,
+// which held the whole document in memory.
+func BenchmarkWritePDFHeapInuse(b *testing.B) {
+	pages := benchmarkPages(b, 200)
+	info, err := os.Stat(pages[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "book.pdf")
+
+	var before, after runtime.MemStats
+	for range b.N {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		if err := writePDFFile(path, pages, 1, false, "Title", "Author", nil); err != nil {
+			b.Fatal(err)
+		}
+		runtime.ReadMemStats(&after)
+	}
+	b.ReportMetric(float64(info.Size()*int64(len(pages)))/(1<<20), "MB-pages")
+	b.ReportMetric(float64(before.HeapInuse)/(1<<20), "MB-heap-before")
+	b.ReportMetric(float64(after.HeapInuse)/(1<<20), "MB-heap-after")
+}
@@ -0,0 +1,66 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var (
+	pdfPagesRe = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfCountRe = regexp.MustCompile(`/Count\s+(\d+)`)
+)
+
+// pdfTailSize is how much of the end of a PDF verifyPDF reads. The page
+// tree follows the page images, so the image data is not read back.
+const pdfTailSize = 4 * 1024 * 1024
+
+// verifyPDF checks that the PDF at path has expectedPages pages. The page
+// count is read from the /Count entry of the root Pages dictionary, the
+// one without a /Parent, so it only understands PDFs whose page tree is not
+// inside a compressed object stream and is written after the pages, such as
+// those written by pdfWriter.
+func verifyPDF(path string, expectedPages int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := max(0, info.Size()-pdfTailSize)
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return err
+	}
+
+	for _, loc := range pdfPagesRe.FindAllIndex(data, -1) {
+		start := bytes.LastIndex(data[:loc[0]], []byte("<<"))
+		end := bytes.Index(data[loc[1]:], []byte(">>"))
+		if start < 0 || end < 0 {
+			continue
+		}
+		dict := data[start : loc[1]+end]
+		if bytes.Contains(dict, []byte("/Parent")) {
+			continue
+		}
+		m := pdfCountRe.FindSubmatch(dict)
+		if m == nil {
+			continue
+		}
+		count, err := strconv.Atoi(string(m[1]))
+		if err != nil {
+			continue
+		}
+		if count != expectedPages {
+			return fmt.Errorf("%s has %d pages, expected %d (%+d)", path, count, expectedPages, count-expectedPages)
+		}
+		return nil
+	}
+	return errors.New(path + " has no readable page tree")
+}
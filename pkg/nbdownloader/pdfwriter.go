@@ -0,0 +1,336 @@
+//go:build !nopdf
+
+package nbdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // register JPEG for image.DecodeConfig
+	"image/png"
+	"io"
+	"os"
+	"time"
+	"unicode/utf16"
+)
+
+// Page geometry in points. Pages are US Letter and the image is drawn at A4
+// size from the top left corner, as gofpdf laid them out before.
+const (
+	pdfPageWidth   = 612.0
+	pdfPageHeight  = 792.0
+	pdfImageWidth  = 595.27559
+	pdfImageHeight = 841.88976
+)
+
+// pdfPagesObject is the object number reserved for the page tree, which the
+// pages refer to before it is written
+const pdfPagesObject = 1
+
+// pdfWriter writes a PDF of full-page JPEG or PNG images one page at a time.
+// Unlike gofpdf, which builds the whole document in memory, it copies each
+// JPEG from its file straight to the output and decodes a PNG only while its
+// page is written, so memory use does not grow with the size of the book.
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int64   // bytes written so far
+	offsets []int64 // offset of each object, index = object number - 1
+	pages   []int   // object numbers of the pages
+	level   int     // zlib level of content streams, 0 leaves them uncompressed
+	cmyk    bool    // convert the pages to CMYK, see cmykProfile
+	profile int     // object number of the CMYK ICC profile, 0 until written
+	err     error   // first write error
+}
+
+// pdfOutlineItem is a bookmark pointing to the top of a page
+type pdfOutlineItem struct {
+	title string
+	page  int // object number of the page
+}
+
+// newPDFWriter writes the PDF header to w. Content streams are compressed
+// at zlib level, or not at all if level is 0.
+func newPDFWriter(w io.Writer, level int) *pdfWriter {
+	pw := &pdfWriter{w: bufio.NewWriterSize(w, 256*1024), level: level}
+	pw.offsets = make([]int64, pdfPagesObject)
+	// The binary comment marks the file as binary for transfer programs
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return pw
+}
+
+// Write writes p to the output, keeping track of the offset. After the first
+// error nothing is written.
+func (pw *pdfWriter) Write(p []byte) (int, error) {
+	if pw.err != nil {
+		return 0, pw.err
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+	return n, err
+}
+
+func (pw *pdfWriter) printf(format string, args ...any) {
+	fmt.Fprintf(pw, format, args...)
+}
+
+// newObject allocates the next object number
+func (pw *pdfWriter) newObject() int {
+	pw.offsets = append(pw.offsets, 0)
+	return len(pw.offsets)
+}
+
+// beginObject starts object n at the current offset
+func (pw *pdfWriter) beginObject(n int) {
+	pw.offsets[n-1] = pw.offset
+	pw.printf("%d 0 obj\n", n)
+}
+
+// object writes object n with the given dictionary or other value
+func (pw *pdfWriter) object(n int, value string) {
+	pw.beginObject(n)
+	pw.printf("%s\nendobj\n", value)
+}
+
+// addPage adds a page showing the JPEG or PNG image at path
+func (pw *pdfWriter) addPage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	img := pw.newObject()
+	switch {
+	case pw.cmyk && (format == "jpeg" || format == "png"):
+		err = pw.cmykImage(img, f)
+	case format == "jpeg":
+		err = pw.jpegImage(img, f, cfg)
+	case format == "png":
+		err = pw.pngImage(img, f)
+	default:
+		return fmt.Errorf("%s: not a JPEG or PNG image", path)
+	}
+	if err != nil {
+		if pw.err == nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return pw.err
+	}
+
+	contents := pw.newObject()
+	pw.stream(contents, fmt.Sprintf("q %.5f 0 0 %.5f 0 %.5f cm /Im Do Q",
+		pdfImageWidth, pdfImageHeight, pdfPageHeight-pdfImageHeight))
+
+	page := pw.newObject()
+	pw.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im %d 0 R >> >> /Contents %d 0 R >>",
+		pdfPagesObject, pdfPageWidth, pdfPageHeight, img, contents))
+	pw.pages = append(pw.pages, page)
+	return pw.err
+}
+
+// jpegImage writes image object n with the JPEG file f, which is embedded as
+// it is
+func (pw *pdfWriter) jpegImage(n int, f *os.File, cfg image.Config) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	colorSpace := "/DeviceRGB"
+	switch cfg.ColorModel {
+	case color.GrayModel:
+		colorSpace = "/DeviceGray"
+	case color.CMYKModel:
+		// Adobe CMYK JPEGs store inverted values
+		colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+	}
+
+	pw.beginObject(n)
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+		cfg.Width, cfg.Height, colorSpace, info.Size())
+	if _, err := io.CopyN(pw, f, info.Size()); err != nil {
+		return err
+	}
+	pw.printf("\nendstream\nendobj\n")
+	return pw.err
+}
+
+// pngImage writes image object n with the PNG file f. PDF has no filter for
+// PNG files, so the pixels are decoded and stored deflated, whether or not
+// the content streams are compressed. Transparent areas become white.
+func (pw *pdfWriter) pngImage(n int, f *os.File) error {
+	src, err := png.Decode(f)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+
+	colorSpace := "/DeviceRGB"
+	var pixels []byte
+	switch src.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		colorSpace = "/DeviceGray"
+		pixels = toGray(src).Pix
+	default:
+		rgba := image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, image.White, image.Point{}, draw.Src)
+		draw.Draw(rgba, bounds, src, bounds.Min, draw.Over)
+		pixels = make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			pixels = append(pixels, rgba.Pix[i:i+3]...)
+		}
+	}
+
+	data := pw.deflate(pixels)
+	pw.beginObject(n)
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n",
+		bounds.Dx(), bounds.Dy(), colorSpace, len(data))
+	pw.Write(data)
+	pw.printf("\nendstream\nendobj\n")
+	return pw.err
+}
+
+// cmykImage writes image object n with the JPEG or PNG file f converted to
+// CMYK with color.CMYKModel. The pixels are stored deflated, as image/jpeg
+// cannot encode CMYK, and tagged with the ICC profile of the conversion.
+// Transparent areas become white.
+func (pw *pdfWriter) cmykImage(n int, f *os.File) error {
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, bounds, src, bounds.Min, draw.Over)
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*4)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		c, m, y, k := color.RGBToCMYK(rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		pixels = append(pixels, c, m, y, k)
+	}
+
+	if pw.profile == 0 {
+		pw.profile = pw.newObject()
+		profile := pw.deflate(cmykProfile())
+		pw.beginObject(pw.profile)
+		pw.printf("<< /N 4 /Alternate /DeviceCMYK /Filter /FlateDecode /Length %d >>\nstream\n", len(profile))
+		pw.Write(profile)
+		pw.printf("\nendstream\nendobj\n")
+	}
+
+	data := pw.deflate(pixels)
+	pw.beginObject(n)
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace [/ICCBased %d 0 R] /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n",
+		bounds.Dx(), bounds.Dy(), pw.profile, len(data))
+	pw.Write(data)
+	pw.printf("\nendstream\nendobj\n")
+	return pw.err
+}
+
+// deflate compresses data with zlib for the FlateDecode filter at the level
+// of the content streams. Decoded images are stored compressed even with
+// level 0, at zlib.BestSpeed.
+func (pw *pdfWriter) deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&buf, max(pw.level, zlib.BestSpeed))
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// addBlankPage adds an empty page
+func (pw *pdfWriter) addBlankPage() error {
+	page := pw.newObject()
+	pw.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << >> >>",
+		pdfPagesObject, pdfPageWidth, pdfPageHeight))
+	pw.pages = append(pw.pages, page)
+	return pw.err
+}
+
+// stream writes object n as a stream with the given content
+func (pw *pdfWriter) stream(n int, content string) {
+	if pw.level == 0 {
+		pw.object(n, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		return
+	}
+	data := pw.deflate([]byte(content))
+	pw.beginObject(n)
+	pw.printf("<< /Filter /FlateDecode /Length %d >>\nstream\n", len(data))
+	pw.Write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// close writes the page tree, the bookmarks, the document information and
+// the cross-reference table, and flushes the output
+func (pw *pdfWriter) close(title, author string, outline []pdfOutlineItem) error {
+	var kids bytes.Buffer
+	for _, page := range pw.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", page)
+	}
+	pw.object(pdfPagesObject, fmt.Sprintf("<< /Type /Pages /Count %d /Kids [ %s] >>", len(pw.pages), kids.String()))
+
+	catalog := "<< /Type /Catalog /Pages 1 0 R >>"
+	if len(outline) > 0 {
+		root := pw.newObject()
+		first := len(pw.offsets) + 1
+		last := first + len(outline) - 1
+		for i, item := range outline {
+			n := pw.newObject()
+			links := ""
+			if i > 0 {
+				links += fmt.Sprintf(" /Prev %d 0 R", n-1)
+			}
+			if i < len(outline)-1 {
+				links += fmt.Sprintf(" /Next %d 0 R", n+1)
+			}
+			pw.object(n, fmt.Sprintf("<< /Title %s /Parent %d 0 R%s /Dest [%d 0 R /XYZ 0 %.2f null] >>",
+				pdfText(item.title), root, links, item.page, pdfPageHeight))
+		}
+		pw.object(root, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", first, last, len(outline)))
+		catalog = fmt.Sprintf("<< /Type /Catalog /Pages 1 0 R /Outlines %d 0 R /PageMode /UseOutlines >>", root)
+	}
+	catalogObject := pw.newObject()
+	pw.object(catalogObject, catalog)
+
+	date := time.Now().Format("D:20060102150405")
+	info := pw.newObject()
+	pw.object(info, fmt.Sprintf("<< /Producer %s /Title %s /Author %s /CreationDate (%s) /ModDate (%s) >>",
+		pdfText("nb-downloader"), pdfText(title), pdfText(author), date, date))
+
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, offset := range pw.offsets {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(pw.offsets)+1, catalogObject, info, xref)
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// pdfText encodes s as a UTF-16BE hex string with byte order mark, which
+// holds any text
+func pdfText(s string) string {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2, 2+2*len(u))
+	b[0], b[1] = 0xFE, 0xFF
+	for _, c := range u {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return "<" + hex.EncodeToString(b) + ">"
+}
@@ -0,0 +1,66 @@
+package nbdownloader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+)
+
+// placeholderRun is the number of consecutive identical pages above which
+// they are taken for the placeholder image nb.no serves when access is
+// denied
+const placeholderRun = 5
+
+// consecutiveDuplicateDetector keeps track of the run of consecutive pages
+// with the same image
+type consecutiveDuplicateDetector struct {
+	last  [sha256.Size]byte
+	pages []string // pages of the current run
+}
+
+// add records the image of the next page and returns the pages of the run it
+// belongs to
+func (d *consecutiveDuplicateDetector) add(pageID string, data []byte) []string {
+	sum := sha256.Sum256(data)
+	if len(d.pages) == 0 || sum != d.last {
+		d.last, d.pages = sum, d.pages[:0]
+	}
+	d.pages = append(d.pages, pageID)
+	return d.pages
+}
+
+// reset ends the current run, e.g. after a page failed
+func (d *consecutiveDuplicateDetector) reset() {
+	d.pages = d.pages[:0]
+}
+
+// checkPlaceholder adds the saved image of a page to the detector and warns
+// once the run of identical pages grows beyond placeholderRun. The pages of
+// the run are then removed from the page cache, so that they are downloaded
+// again after logging in. With failOnDuplicates it returns a
+// *PlaceholderError.
+func (d *download) checkPlaceholder(dup *consecutiveDuplicateDetector, pageID string) error {
+	data, err := os.ReadFile(d.pagePath(pageID))
+	if err != nil {
+		dup.reset()
+		return nil
+	}
+	run := dup.add(pageID, data)
+	if len(run) != placeholderRun+1 {
+		return nil
+	}
+
+	d.progress.interrupt()
+	fmt.Fprintln(d.log)
+	fmt.Fprintln(d.log, "WARNING: Possible authentication failure: identical images detected")
+	fmt.Fprintf(d.log, "Pages %s to %s are the same image, probably the placeholder nb.no shows\n", run[0], pageID)
+	fmt.Fprintln(d.log, "for pages you have no access to. Check your cookies.")
+	fmt.Fprintln(d.log)
+	for _, p := range run {
+		d.cache.remove(d.formatURL(d.pageParams(p, d.PageNrWidth())))
+	}
+	if d.failOnDuplicates {
+		return &PlaceholderError{FirstPage: run[0], LastPage: pageID}
+	}
+	return nil
+}
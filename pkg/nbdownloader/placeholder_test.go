@@ -0,0 +1,33 @@
+package nbdownloader
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCheckPlaceholderRemovesCachedRun(t *testing.T) {
+	b := NewBook("2008011100001", DownloadOptions{TempDir: t.TempDir(), CacheDir: t.TempDir()})
+	if err := os.MkdirAll(b.fullpath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	d := b.newDownload()
+	var dup consecutiveDuplicateDetector
+	for n := 1; n <= placeholderRun+1; n++ {
+		page := strconv.Itoa(n)
+		if err := os.WriteFile(b.pagePath(page), []byte("placeholder"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.cache.store(b.PageURL(page), []byte("placeholder")); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.checkPlaceholder(&dup, page); err != nil {
+			t.Fatalf("checkPlaceholder(%s) = %v without failOnDuplicates", page, err)
+		}
+	}
+	for n := 1; n <= placeholderRun+1; n++ {
+		if _, ok := b.cache.read(b.PageURL(strconv.Itoa(n))); ok {
+			t.Errorf("page %d of the placeholder run is still cached", n)
+		}
+	}
+}
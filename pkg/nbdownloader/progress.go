@@ -0,0 +1,288 @@
+package nbdownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressMode selects how download progress is reported
+type progressMode int
+
+const (
+	progressPlain progressMode = iota // one line per page event
+	progressBar                       // a single updating line on a terminal
+	progressJSON                      // one JSON object per page
+	progressHook                      // events are only passed to onPage
+)
+
+// PageEvent reports that a page has finished downloading, successfully or not
+type PageEvent struct {
+	Page  string        // page ID, e.g. "C1", "I1" or "42"
+	Done  int           // pages finished so far, including this one
+	Total int           // pages in the download
+	Bytes int64         // bytes received for the page
+	Rate  float64       // recent transfer rate in bytes per second
+	ETA   time.Duration // estimated time until the download is complete
+	Err   error         // nil if the page was downloaded
+}
+
+// DownloadStats counts the pages and bytes of a download. Other goroutines
+// may read it while the book downloads, through Snapshot, which takes all
+// counters at the same point in time.
+type DownloadStats struct {
+	Total   int       // pages in the download
+	Done    int       // pages finished, downloaded or failed
+	Failed  int       // pages that could not be downloaded
+	Bytes   int64     // bytes received
+	Started time.Time // when the first page was requested
+
+	mu *sync.Mutex // guards the counters, nil in snapshots
+}
+
+// newDownloadStats creates the counters of a download of total pages
+func newDownloadStats(total int) *DownloadStats {
+	return &DownloadStats{Total: total, Started: time.Now(), mu: new(sync.Mutex)}
+}
+
+// Snapshot returns a copy of the counters that doesn't change while the
+// download goes on. A nil *DownloadStats gives zero counters.
+func (s *DownloadStats) Snapshot() DownloadStats {
+	if s == nil {
+		return DownloadStats{}
+	}
+	if s.mu == nil {
+		return *s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := *s
+	snapshot.mu = nil
+	return snapshot
+}
+
+// addBytes counts n more bytes received
+func (s *DownloadStats) addBytes(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Bytes += n
+}
+
+// pageDone counts a finished page, failed or not
+func (s *DownloadStats) pageDone(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Done++
+	if failed {
+		s.Failed++
+	}
+}
+
+// progressWindow is the number of recent pages used for the transfer rate
+const progressWindow = 10
+
+// progressSample records the bytes and wall time spent on one page
+type progressSample struct {
+	bytes    int64
+	duration time.Duration
+}
+
+// progress tracks and displays the download progress of a book. A nil
+// *progress is valid and behaves like plain output. Only the counters in
+// stats may be read by other goroutines.
+type progress struct {
+	mode      progressMode
+	out       io.Writer
+	stats     *DownloadStats
+	pageBytes int64 // bytes received for the current page
+	pageStart time.Time
+	samples   []progressSample
+	barShown  bool
+	onPage    func(PageEvent)
+}
+
+// newProgress creates a progress display for total pages written to out. The
+// bar is only used when out is a terminal. If onPage is set, page events are
+// passed to it instead of being displayed.
+func newProgress(total int, jsonOutput bool, out io.Writer, onPage func(PageEvent)) *progress {
+	mode := progressPlain
+	if onPage != nil {
+		mode = progressHook
+	} else if jsonOutput {
+		mode = progressJSON
+	} else if isTerminal(out) {
+		mode = progressBar
+	}
+	return &progress{mode: mode, out: out, stats: newDownloadStats(total), pageStart: time.Now(), onPage: onPage}
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// verbose reports whether per-page log lines should be printed
+func (p *progress) verbose() bool {
+	return p == nil || p.mode == progressPlain
+}
+
+// interrupt clears the progress bar so a log line can be printed
+func (p *progress) interrupt() {
+	if p != nil && p.barShown {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.barShown = false
+	}
+}
+
+// addBytes records bytes received for the current page
+func (p *progress) addBytes(n int64) {
+	if p != nil {
+		p.pageBytes += n
+		p.stats.addBytes(n)
+	}
+}
+
+// bytes returns the number of bytes received for all pages
+func (p *progress) bytes() int64 {
+	return p.snapshot().Bytes
+}
+
+// snapshot returns the counters of the download, zero for a nil *progress
+func (p *progress) snapshot() DownloadStats {
+	if p == nil {
+		return DownloadStats{}
+	}
+	return p.stats.Snapshot()
+}
+
+// pageDone records that a page has finished, successfully or not
+func (p *progress) pageDone(pageID string, err error) {
+	if p == nil {
+		return
+	}
+
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{bytes: p.pageBytes, duration: now.Sub(p.pageStart)})
+	if len(p.samples) > progressWindow {
+		p.samples = p.samples[1:]
+	}
+	p.stats.pageDone(err != nil)
+	stats := p.stats.Snapshot()
+	pageBytes := p.pageBytes
+	p.pageBytes = 0
+	p.pageStart = now
+
+	switch p.mode {
+	case progressHook:
+		p.onPage(PageEvent{
+			Page:  pageID,
+			Done:  stats.Done,
+			Total: stats.Total,
+			Bytes: pageBytes,
+			Rate:  p.rate(),
+			ETA:   p.eta(),
+			Err:   err,
+		})
+	case progressBar:
+		p.renderBar()
+	case progressJSON:
+		event := struct {
+			Page       string  `json:"page"`
+			Done       int     `json:"done"`
+			Total      int     `json:"total"`
+			Bytes      int64   `json:"bytes"`
+			Rate       float64 `json:"bytes_per_second"`
+			ETASeconds float64 `json:"eta_seconds"`
+			Error      string  `json:"error,omitempty"`
+		}{
+			Page:       pageID,
+			Done:       stats.Done,
+			Total:      stats.Total,
+			Bytes:      pageBytes,
+			Rate:       p.rate(),
+			ETASeconds: p.eta().Seconds(),
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		data, _ := json.Marshal(event)
+		fmt.Fprintln(p.out, string(data))
+	}
+}
+
+// finish ends the progress bar line
+func (p *progress) finish() {
+	if p != nil && p.barShown {
+		fmt.Fprintln(p.out)
+		p.barShown = false
+	}
+}
+
+// rate returns the average transfer rate over the recent pages in bytes/s
+func (p *progress) rate() float64 {
+	var bytes int64
+	var duration time.Duration
+	for _, s := range p.samples {
+		bytes += s.bytes
+		duration += s.duration
+	}
+	if duration <= 0 {
+		return 0
+	}
+	return float64(bytes) / duration.Seconds()
+}
+
+// eta estimates the remaining time from the recent time per page
+func (p *progress) eta() time.Duration {
+	if len(p.samples) == 0 {
+		return 0
+	}
+	var duration time.Duration
+	for _, s := range p.samples {
+		duration += s.duration
+	}
+	perPage := duration / time.Duration(len(p.samples))
+	stats := p.stats.Snapshot()
+	return perPage * time.Duration(stats.Total-stats.Done)
+}
+
+// renderBar draws e.g. [====>    ] 42/500 pages (8.4%) | 1.2 MB/s | ETA 3m22s
+func (p *progress) renderBar() {
+	const width = 30
+
+	stats := p.stats.Snapshot()
+	fraction := 1.0
+	if stats.Total > 0 {
+		fraction = float64(stats.Done) / float64(stats.Total)
+	}
+	filled := int(fraction * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	fmt.Fprintf(p.out, "\r\033[K[%s] %d/%d pages (%.1f%%) | %s/s | ETA %s",
+		bar, stats.Done, stats.Total, fraction*100, FormatBytes(int64(p.rate())), p.eta().Round(time.Second))
+	p.barShown = true
+}
+
+// countingReader counts the bytes read through it into a progress display
+type countingReader struct {
+	r        io.Reader
+	progress *progress
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.progress.addBytes(int64(n))
+	return n, err
+}
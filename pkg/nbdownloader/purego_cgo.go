@@ -0,0 +1,8 @@
+//go:build purego && cgo
+
+package nbdownloader
+
+// Builds with the purego tag promise a binary without C code, which only
+// holds with cgo turned off. This file is only compiled when both are on,
+// and its declaration fails to compile with an error quoting the fix.
+var _ int = "the purego build tag requires CGO_ENABLED=0"
@@ -0,0 +1,98 @@
+package nbdownloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CropMargin is the border in pixels of the full-size scan that the IIIF
+// server cuts off each page, e.g. to remove the scanner bed around it
+type CropMargin struct {
+	Top, Right, Bottom, Left int
+}
+
+// IsZero reports whether no margin is cut off
+func (m CropMargin) IsZero() bool {
+	return m == CropMargin{}
+}
+
+// ParseCropMargin parses a margin given as top,right,bottom,left in pixels,
+// e.g. "10,10,10,10"
+func ParseCropMargin(s string) (CropMargin, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return CropMargin{}, fmt.Errorf("invalid crop margin %q, expected top,right,bottom,left in pixels", s)
+	}
+	var sides [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return CropMargin{}, fmt.Errorf("invalid crop margin %q, expected top,right,bottom,left in pixels", s)
+		}
+		sides[i] = n
+	}
+	return CropMargin{Top: sides[0], Right: sides[1], Bottom: sides[2], Left: sides[3]}, nil
+}
+
+// applyMarginCrop makes the download's URL template request the region of
+// the full-size scan that starts at x,y and is w by h pixels large instead
+// of the full page. The Book's template is left alone, so PageURL and
+// clones of the book still get the full page.
+func (d *download) applyMarginCrop(x, y, w, h int) string {
+	region := fmt.Sprintf("%d,%d,%d,%d", x, y, w, h)
+	d.urlTemplate = strings.Replace(d.Book.urlTemplate, "/full/", "/"+region+"/", 1)
+	return region
+}
+
+// resolveCropRegion reads the full size of the first page to download and
+// sets the region left inside the crop margin. The same region is requested
+// for every page; IIIF servers clip it to the pages that are smaller.
+func (d *download) resolveCropRegion(ctx context.Context) error {
+	start, _ := d.pageRange()
+	pageNr := strconv.Itoa(start)
+	width, height, err := d.fullImageSize(ctx, pageNr)
+	if err != nil {
+		return err
+	}
+
+	m := d.cropMargin
+	if m.Left+m.Right >= width || m.Top+m.Bottom >= height {
+		return fmt.Errorf("crop margin %d,%d,%d,%d leaves nothing of page %s, which is %dx%d pixels",
+			m.Top, m.Right, m.Bottom, m.Left, pageNr, width, height)
+	}
+	region := d.applyMarginCrop(m.Left, m.Top, width-m.Left-m.Right, height-m.Top-m.Bottom)
+	fmt.Fprintf(d.log, "Cropping pages to region %s of %dx%d pixels\n", region, width, height)
+	return nil
+}
+
+// fullImageSize reads the size of the full-size scan of a page from its
+// IIIF info.json
+func (b *Book) fullImageSize(ctx context.Context, pageNr string) (width, height int, err error) {
+	pageURL := b.PageURL(pageNr)
+	infoURL := pageURL[:strings.LastIndex(pageURL, "/full/")] + "/info.json"
+	resp, err := b.get(ctx, http.MethodGet, infoURL)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading size of page %s: %w", pageNr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("error reading size of page %s: HTTP Status %d", pageNr, resp.StatusCode)
+	}
+
+	var info struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, 0, fmt.Errorf("error parsing size of page %s: %w", pageNr, err)
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return 0, 0, fmt.Errorf("error reading size of page %s: info.json lists no size", pageNr)
+	}
+	return info.Width, info.Height, nil
+}
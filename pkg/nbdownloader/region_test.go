@@ -0,0 +1,53 @@
+package nbdownloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveCropRegion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "_0001/info.json") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"width": 1000, "height": 1500}`))
+	}))
+	defer server.Close()
+
+	b := NewBook("2008011100001", DownloadOptions{
+		BaseURL:    server.URL,
+		Length:     3,
+		CropMargin: CropMargin{Top: 10, Right: 20, Bottom: 30, Left: 40},
+	})
+	b.log = io.Discard
+	full := b.PageURL("1")
+
+	d := b.newDownload()
+	if err := d.resolveCropRegion(context.Background()); err != nil {
+		t.Fatalf("resolveCropRegion() = %v", err)
+	}
+	if url := d.newPageContext("1").url; !strings.Contains(url, "/40,10,940,1460/602,/") {
+		t.Errorf("cropped page URL = %s, want region 40,10,940,1460", url)
+	}
+
+	// The Book and its clones still request the full page
+	if url := b.PageURL("1"); url != full {
+		t.Errorf("PageURL() after cropping = %s, want %s", url, full)
+	}
+	if url := b.Clone("2008011100002").PageURL("1"); !strings.Contains(url, "/full/602,/") {
+		t.Errorf("PageURL() of a clone = %s, want the full page", url)
+	}
+	if url := b.newDownload().newPageContext("1").url; url != full {
+		t.Errorf("page URL of the next download = %s, want %s", url, full)
+	}
+
+	b.cropMargin = CropMargin{Left: 600, Right: 400}
+	if err := b.newDownload().resolveCropRegion(context.Background()); err == nil {
+		t.Error("resolveCropRegion() with a margin wider than the page succeeded")
+	}
+}
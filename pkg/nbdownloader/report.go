@@ -0,0 +1,146 @@
+package nbdownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Report summarizes a download so that users can check that they got the
+// whole book and spot systematic failures, such as every page being denied
+type Report struct {
+	BookID          string         `json:"book_id"`
+	PagesAttempted  int            `json:"pages_attempted"`
+	PagesDownloaded int            `json:"pages_downloaded"`
+	PagesSkipped    int            `json:"pages_skipped"` // already present from an earlier run
+	PagesFailed     int            `json:"pages_failed"`
+	Failures        map[string]int `json:"failures,omitempty"` // failed pages by cause, e.g. "HTTP 404"
+	Duration        time.Duration  `json:"-"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	BytesDownloaded int64          `json:"bytes_downloaded"`
+	BytesPerSecond  float64        `json:"bytes_per_second"`
+	Output          string         `json:"output,omitempty"`
+	OutputSize      int64          `json:"output_size,omitempty"`
+	Error           string         `json:"error,omitempty"` // why the download did not complete
+
+	// PageNumbers holds the printed page number of each page by page ID if
+	// InferPageNumbers is set
+	PageNumbers map[string]string `json:"page_numbers,omitempty"`
+}
+
+// newReport summarizes the download that started at start and ended with err
+func (d *download) newReport(start time.Time, err error) *Report {
+	duration := time.Since(start)
+	r := &Report{
+		BookID:          d.id,
+		PagesAttempted:  d.attempted,
+		PagesSkipped:    d.skipped,
+		Failures:        make(map[string]int),
+		Duration:        duration,
+		DurationSeconds: duration.Seconds(),
+		BytesDownloaded: d.progress.bytes(),
+		Output:          d.outPath,
+		PageNumbers:     d.pageNumbers,
+	}
+	if duration > 0 {
+		r.BytesPerSecond = float64(r.BytesDownloaded) / duration.Seconds()
+	}
+
+	failures := d.pageErrors
+	if err != nil {
+		r.Error = err.Error()
+		if isFatal(err) && d.attempted > 0 {
+			// The page that aborted the download
+			failures = append(slices.Clone(failures), err)
+		}
+	}
+	for _, pageErr := range failures {
+		r.Failures[failureCause(pageErr)]++
+	}
+	r.PagesFailed = len(failures)
+	r.PagesDownloaded = r.PagesAttempted - r.PagesFailed
+
+	for _, path := range d.outFiles {
+		r.OutputSize += pathSize(path)
+	}
+	return r
+}
+
+// failureCause classifies a page error for the report
+func failureCause(err error) string {
+	var authErr *AuthError
+	var notFoundErr *PageNotFoundError
+	var networkErr *NetworkError
+	var storageErr *StorageError
+	switch {
+	case errors.As(err, &authErr):
+		return fmt.Sprintf("HTTP %d", authErr.StatusCode)
+	case errors.As(err, &notFoundErr):
+		return "HTTP 404"
+	case errors.As(err, &networkErr) && networkErr.StatusCode != 0:
+		return fmt.Sprintf("HTTP %d", networkErr.StatusCode)
+	case errors.As(err, &networkErr):
+		return "network error"
+	case errors.As(err, &storageErr):
+		return "storage error"
+	}
+	return "other"
+}
+
+// pathSize returns the size of a file, or of all files below a folder
+func pathSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// print writes the report as a human-readable summary
+func (r *Report) print(w io.Writer) {
+	failed := fmt.Sprint(r.PagesFailed)
+	if len(r.Failures) > 0 {
+		var causes []string
+		for _, cause := range slices.Sorted(maps.Keys(r.Failures)) {
+			causes = append(causes, fmt.Sprintf("%s: %d", cause, r.Failures[cause]))
+		}
+		failed += " (" + strings.Join(causes, ", ") + ")"
+	}
+
+	fmt.Fprintf(w, "Download summary for book %s:\n", r.BookID)
+	fmt.Fprintf(w, "  Pages attempted:   %d\n", r.PagesAttempted)
+	fmt.Fprintf(w, "  Pages downloaded:  %d\n", r.PagesDownloaded)
+	fmt.Fprintf(w, "  Pages skipped:     %d\n", r.PagesSkipped)
+	fmt.Fprintf(w, "  Pages failed:      %s\n", failed)
+	fmt.Fprintf(w, "  Total time:        %s\n", r.Duration.Round(100*time.Millisecond))
+	fmt.Fprintf(w, "  Average speed:     %s/s\n", FormatBytes(int64(r.BytesPerSecond)))
+	if r.Output != "" {
+		fmt.Fprintf(w, "  Output:            %s (%s)\n", r.Output, FormatBytes(r.OutputSize))
+	}
+	if r.Error != "" {
+		fmt.Fprintf(w, "  Not completed:     %s\n", r.Error)
+	}
+}
+
+// save writes the report as JSON
+func (r *Report) save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
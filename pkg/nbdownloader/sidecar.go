@@ -0,0 +1,62 @@
+package nbdownloader
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// SidecarSchemaVersion is the version of the sidecar file format. It is
+// increased whenever a field is changed or removed; new fields may be added
+// without a new version.
+const SidecarSchemaVersion = 1
+
+// Sidecar describes a download in <bookID>.json next to the output, so that
+// other tools can catalogue it without parsing the PDF
+type Sidecar struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	IssueDate     string    `json:"issue_date,omitempty"`
+	Output        []string  `json:"output"` // files or folders written, several for split PDFs
+	PageCount     int       `json:"page_count"`
+	Pages         []string  `json:"pages"` // page IDs in the order of the output
+	DownloadedAt  time.Time `json:"downloaded_at"`
+	ImageWidth    int       `json:"image_width"`
+	URLTemplate   string    `json:"url_template"` // page image URL with a {long_page_nr} placeholder
+}
+
+// sidecarPath returns the path of the sidecar file
+func (b *Book) sidecarPath() string {
+	return b.name + ".json"
+}
+
+// writeSidecar saves the sidecar file for the pages of a finished download
+func (d *download) writeSidecar(pages []string) error {
+	pageIDs := make([]string, len(pages))
+	for i, page := range pages {
+		pageIDs[i] = pageIDOf(page)
+	}
+
+	sidecar := Sidecar{
+		SchemaVersion: SidecarSchemaVersion,
+		ID:            d.id,
+		Type:          d.documentType,
+		IssueDate:     d.issueDate,
+		Output:        d.outFiles,
+		PageCount:     len(pages),
+		Pages:         pageIDs,
+		DownloadedAt:  time.Now().UTC().Truncate(time.Second),
+		ImageWidth:    d.imageWidth,
+		URLTemplate:   strings.ReplaceAll(d.urlTemplate, "{book_id}", d.id),
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d.sidecarPath(), append(data, '\n'), 0644); err != nil {
+		return &StorageError{Path: d.sidecarPath(), Err: err}
+	}
+	return nil
+}
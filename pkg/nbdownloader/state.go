@@ -0,0 +1,74 @@
+package nbdownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Page states in the state file
+const (
+	pageDone   = "done"
+	pageFailed = "failed"
+)
+
+// downloadState records which pages of a download succeeded or failed. It
+// is saved as <name>_state.json until the download completes, so that
+// RetryFailed can fetch only the pages that are still missing.
+type downloadState struct {
+	BookID string            `json:"book_id"`
+	Type   string            `json:"type"`
+	Pages  map[string]string `json:"pages"` // "done" or "failed" by page ID
+}
+
+// statePath returns the path of the state file
+func (b *Book) statePath() string {
+	return b.name + "_state.json"
+}
+
+// loadState reads the state file of an earlier run
+func (b *Book) loadState() (*downloadState, error) {
+	data, err := os.ReadFile(b.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no download state in %s to retry, the last download of %s completed or never ran", b.statePath(), b.id)
+	}
+	if err != nil {
+		return nil, &StorageError{Path: b.statePath(), Err: err}
+	}
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", b.statePath(), err)
+	}
+	if state.Pages == nil {
+		state.Pages = make(map[string]string)
+	}
+	return &state, nil
+}
+
+// saveState writes the state file
+func (b *Book) saveState(state *downloadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.statePath(), append(data, '\n'), 0644); err != nil {
+		return &StorageError{Path: b.statePath(), Err: err}
+	}
+	return nil
+}
+
+// pagesToRetry returns the pages of pageIDs that the state does not record
+// as done, or whose image is no longer in the temporary folder
+func (b *Book) pagesToRetry(state *downloadState, pageIDs []string) []string {
+	var retry []string
+	for _, pageID := range pageIDs {
+		if state.Pages[pageID] == pageDone {
+			if _, err := os.Stat(b.pagePath(pageID)); err == nil {
+				continue
+			}
+		}
+		retry = append(retry, pageID)
+	}
+	return retry
+}
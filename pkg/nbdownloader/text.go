@@ -0,0 +1,292 @@
+package nbdownloader
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sentenceEnds lists the characters that end a sentence, including closing
+// quotes and brackets after the final punctuation
+const sentenceEnds = ".!?:…»”\")"
+
+// pageParagraphs splits the OCR text of a page into paragraphs, which
+// tesseract separates by blank lines. The lines of a paragraph are joined,
+// and a page number on a line of its own is dropped.
+func pageParagraphs(text string) []string {
+	var paragraphs []string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			paragraphs = append(paragraphs, joinLines(current))
+			current = nil
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flush()
+		case pageNumberRe.MatchString(line):
+			// A page number in the header or footer
+		default:
+			current = append(current, line)
+		}
+	}
+	flush()
+	return paragraphs
+}
+
+// joinLines joins the lines of a paragraph with spaces. A word hyphenated
+// at the end of a line is put back together if the next line continues in
+// lower case.
+func joinLines(lines []string) string {
+	var sb strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			next, _ := utf8.DecodeRuneInString(line)
+			if !(strings.HasSuffix(prev, "-") && unicode.IsLower(next)) {
+				sb.WriteByte(' ')
+			}
+		}
+		if i < len(lines)-1 && strings.HasSuffix(line, "-") {
+			next, _ := utf8.DecodeRuneInString(lines[i+1])
+			if unicode.IsLower(next) {
+				line = strings.TrimSuffix(line, "-")
+			}
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// endsSentence reports whether a paragraph ends with the end of a sentence
+func endsSentence(paragraph string) bool {
+	last, _ := utf8.DecodeLastRuneInString(paragraph)
+	return strings.ContainsRune(sentenceEnds, last)
+}
+
+// joinSentencesAcrossPages combines the OCR text of consecutive pages into
+// one text with a blank line between paragraphs. A paragraph that does not
+// end a sentence at the bottom of a page continues on the next page, so it
+// is joined with the first paragraph there.
+func joinSentencesAcrossPages(pages []string) string {
+	var paragraphs []string
+	continues := false
+	for _, page := range pages {
+		for i, p := range pageParagraphs(page) {
+			if i == 0 && continues && len(paragraphs) > 0 {
+				last := len(paragraphs) - 1
+				paragraphs[last] = joinLines([]string{paragraphs[last], p})
+			} else {
+				paragraphs = append(paragraphs, p)
+			}
+		}
+		continues = len(paragraphs) > 0 && !endsSentence(paragraphs[len(paragraphs)-1])
+	}
+	if len(paragraphs) == 0 {
+		return ""
+	}
+	return strings.Join(paragraphs, "\n\n") + "\n"
+}
+
+// ocrPages runs OCR on the pages, leaving out the covers, and returns the
+// IDs of the pages read and their text. The text of each page is kept in
+// PageTextDir for SearchPageText.
+func (b *Book) ocrPages(ctx context.Context, tesseract string, pages []string) ([]string, []string, error) {
+	textDir := PageTextDir(b.name)
+	if err := os.RemoveAll(textDir); err != nil {
+		return nil, nil, &StorageError{Path: textDir, Err: err}
+	}
+	if err := os.MkdirAll(textDir, 0755); err != nil {
+		return nil, nil, &StorageError{Path: textDir, Err: err}
+	}
+
+	var pageIDs, texts []string
+	for i, page := range pages {
+		pageID := pageIDOf(page)
+		if isCoverPage(pageID) {
+			continue
+		}
+		fmt.Fprintf(b.log, "\rReading page text: %d/%d", i+1, len(pages))
+		text, err := runTesseract(ctx, tesseract, page)
+		if err != nil {
+			fmt.Fprintln(b.log)
+			return nil, nil, fmt.Errorf("error reading text of %s: %w", pageID, err)
+		}
+		pageIDs = append(pageIDs, pageID)
+		texts = append(texts, text)
+
+		textPath := filepath.Join(textDir, pageID+".txt")
+		if err := os.WriteFile(textPath, []byte(text), 0644); err != nil {
+			fmt.Fprintln(b.log)
+			return nil, nil, &StorageError{Path: textPath, Err: err}
+		}
+	}
+	fmt.Fprintln(b.log)
+	return pageIDs, texts, nil
+}
+
+// saveParagraphs saves the page texts with paragraphs joined across page
+// breaks as <name>_paragraphs.txt. It returns the path of the text file.
+func (b *Book) saveParagraphs(texts []string) (string, error) {
+	outPath := b.name + "_paragraphs.txt"
+	if err := os.WriteFile(outPath, []byte(joinSentencesAcrossPages(texts)), 0644); err != nil {
+		return "", &StorageError{Path: outPath, Err: err}
+	}
+	return outPath, nil
+}
+
+// PageTextDir returns the folder in which -ocr-text keeps the text of each
+// page of the download called name, as <pageID>.txt
+func PageTextDir(name string) string {
+	return name + "_text"
+}
+
+// TextMatch is an occurrence of a search query in the text of a page
+type TextMatch struct {
+	Page    string `json:"page"`    // page number, or page ID for unnumbered pages such as I1
+	Context string `json:"context"` // the query with the text around it
+}
+
+// PageText is the text of one page as saved by -ocr-text
+type PageText struct {
+	Page string // page number, or page ID for unnumbered pages such as I1
+	Text string // with line breaks and runs of spaces replaced by single spaces
+}
+
+// ReadPageTexts reads the page texts in dir, as saved by -ocr-text, in
+// page order
+func ReadPageTexts(dir string) ([]PageText, error) {
+	pageIDs, raw, err := readPageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]PageText, len(pageIDs))
+	for i, pageID := range pageIDs {
+		texts[i] = PageText{Page: displayPage(pageID), Text: strings.Join(strings.Fields(raw[i]), " ")}
+	}
+	return texts, nil
+}
+
+// readPageFiles returns the IDs of the page texts in dir in page order and
+// the texts as tesseract wrote them
+func readPageFiles(dir string) ([]string, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pageIDs []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".txt") {
+			pageIDs = append(pageIDs, strings.TrimSuffix(name, ".txt"))
+		}
+	}
+	slices.SortFunc(pageIDs, comparePageIDs)
+
+	raw := make([]string, len(pageIDs))
+	for i, pageID := range pageIDs {
+		data, err := os.ReadFile(filepath.Join(dir, pageID+".txt"))
+		if err != nil {
+			return nil, nil, err
+		}
+		raw[i] = string(data)
+	}
+	return pageIDs, raw, nil
+}
+
+// SearchPageText searches the page texts in dir, as saved by -ocr-text, for
+// query regardless of case. Each match comes with up to contextLen
+// characters of text on either side. Matches are returned in page order.
+func SearchPageText(dir, query string, contextLen int) ([]TextMatch, error) {
+	pages, err := ReadPageTexts(dir)
+	if err != nil {
+		return nil, err
+	}
+	matches := []TextMatch{}
+	for _, page := range pages {
+		text := []rune(page.Text)
+		for _, i := range foldIndexes(text, query) {
+			matches = append(matches, TextMatch{
+				Page:    page.Page,
+				Context: snippet(text, i, i+utf8.RuneCountInString(query), contextLen),
+			})
+		}
+	}
+	return matches, nil
+}
+
+// Snippet returns the first occurrence of query in text, regardless of
+// case, with up to contextLen characters on either side, or "" if there is
+// none
+func Snippet(text, query string, contextLen int) string {
+	runes := []rune(text)
+	found := foldIndexes(runes, query)
+	if len(found) == 0 {
+		return ""
+	}
+	return snippet(runes, found[0], found[0]+utf8.RuneCountInString(query), contextLen)
+}
+
+// foldIndexes returns the positions in runes at which query occurs in text
+// regardless of case, leaving out overlapping occurrences
+func foldIndexes(text []rune, query string) []int {
+	needle := []rune(query)
+	var found []int
+	for i := 0; len(needle) > 0 && i+len(needle) <= len(text); i++ {
+		if !slices.EqualFunc(text[i:i+len(needle)], needle, func(a, b rune) bool {
+			return unicode.ToLower(a) == unicode.ToLower(b)
+		}) {
+			continue
+		}
+		found = append(found, i)
+		i += len(needle) - 1
+	}
+	return found
+}
+
+// snippet returns text[start:end] with up to contextLen characters on
+// either side, marking text cut off with "..."
+func snippet(text []rune, start, end, contextLen int) string {
+	from, to := max(start-contextLen, 0), min(end+contextLen, len(text))
+	s := string(text[from:to])
+	if from > 0 {
+		s = "..." + s
+	}
+	if to < len(text) {
+		s += "..."
+	}
+	return s
+}
+
+// comparePageIDs orders page IDs as in the book: introduction pages before
+// the numbered pages
+func comparePageIDs(a, b string) int {
+	intro := func(id string) bool { return strings.HasPrefix(id, "I") }
+	if intro(a) != intro(b) {
+		if intro(a) {
+			return -1
+		}
+		return 1
+	}
+	if len(a) != len(b) {
+		// I2 before I10
+		return cmp.Compare(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// displayPage returns a numbered page ID without its leading zeros
+func displayPage(pageID string) string {
+	if trimmed := strings.TrimLeft(pageID, "0"); trimmed != pageID && trimmed != "" {
+		return trimmed
+	}
+	return pageID
+}
@@ -0,0 +1,160 @@
+package nbdownloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseBandwidth parses a download rate in bytes per second. The number may
+// have an SI suffix: k (1000), m (1000²) or g (1000³), e.g. 500k or 2m.
+func ParseBandwidth(s string) (int64, error) {
+	num := strings.ToLower(strings.TrimSpace(s))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(num, "k"):
+		multiplier = 1e3
+	case strings.HasSuffix(num, "m"):
+		multiplier = 1e6
+	case strings.HasSuffix(num, "g"):
+		multiplier = 1e9
+	}
+	if multiplier > 1 {
+		num = num[:len(num)-1]
+	}
+
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, expected a positive number of bytes per second with an optional k, m or g suffix", s)
+	}
+	return max(int64(value*multiplier), 1), nil
+}
+
+// tokenBucket limits the combined rate of all reads that share it. Each
+// byte read takes a token; tokens are refilled at rate per second up to one
+// second's worth, so short bursts are allowed but the average rate is not
+// exceeded. The bucket is shared by every response of a Book, so the limit
+// applies to the whole download rather than each connection.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64 // may go negative while readers wait for their share
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket for rate bytes per second, or nil
+// for no limit
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take removes n tokens and waits until the bucket is no longer in debt.
+// Taking first and waiting afterwards keeps concurrent readers in order.
+func (t *tokenBucket) take(ctx context.Context, n int) error {
+	t.mu.Lock()
+	now := time.Now()
+	t.tokens = min(t.tokens+now.Sub(t.last).Seconds()*t.rate, t.rate)
+	t.last = now
+	t.tokens -= float64(n)
+	debt := -t.tokens
+	t.mu.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+	return sleep(ctx, time.Duration(debt/t.rate*float64(time.Second)))
+}
+
+// ThrottledReader limits the rate at which a reader, such as a response
+// body, is read. The readers of one Limiter share its bandwidth, so their
+// combined rate stays within it, however many there are.
+type ThrottledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	bucket *tokenBucket // nil for no limit
+}
+
+// NewThrottledReader returns a reader of r limited to the bandwidth of l.
+// A Read waiting for its share of the bandwidth returns early with ctx's
+// error when ctx is done.
+func NewThrottledReader(ctx context.Context, r io.Reader, l *Limiter) *ThrottledReader {
+	return &ThrottledReader{ctx: ctx, r: r, bucket: l.bandwidth}
+}
+
+// throttle wraps r in a ThrottledReader if the bucket sets a limit
+func throttle(ctx context.Context, r io.Reader, bucket *tokenBucket) io.Reader {
+	if bucket == nil {
+		return r
+	}
+	return &ThrottledReader{ctx: ctx, r: r, bucket: bucket}
+}
+
+func (t *ThrottledReader) Read(buf []byte) (int, error) {
+	if t.bucket == nil {
+		return t.r.Read(buf)
+	}
+	// Reading at most a second's worth at a time keeps the waits short
+	if limit := int(t.bucket.rate); len(buf) > limit {
+		buf = buf[:limit]
+	}
+	n, err := t.r.Read(buf)
+	if n > 0 {
+		if waitErr := t.bucket.take(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Limiter is a rate limit shared by books downloaded at the same time, see
+// DownloadOptions.Limiter. Their combined download rate stays within the
+// bandwidth, and when nb.no rate limits one of them with HTTP 429, all of
+// them wait before their next request.
+type Limiter struct {
+	bandwidth *tokenBucket
+
+	mu     sync.Mutex
+	resume time.Time // no requests before this time
+}
+
+// NewLimiter returns a Limiter for a combined bandwidth in bytes per second,
+// 0 for no limit
+func NewLimiter(bandwidth int64) *Limiter {
+	return &Limiter{bandwidth: newTokenBucket(bandwidth)}
+}
+
+// limiter returns the shared Limiter of the options, or a Limiter of its own
+// for the Bandwidth
+func (opts DownloadOptions) limiter() *Limiter {
+	if opts.Limiter != nil {
+		return opts.Limiter
+	}
+	return NewLimiter(opts.Bandwidth)
+}
+
+// pause holds back all requests for d
+func (l *Limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if resume := time.Now().Add(d); resume.After(l.resume) {
+		l.resume = resume
+	}
+}
+
+// wait waits until requests are no longer held back by pause
+func (l *Limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	d := time.Until(l.resume)
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	return sleep(ctx, d)
+}
@@ -0,0 +1,39 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1500)
+
+	// The bucket starts with a second's worth, so the last 500 bytes wait
+	// half a second
+	start := time.Now()
+	got, err := io.ReadAll(NewThrottledReader(context.Background(), bytes.NewReader(data), NewLimiter(1000)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, %v, want %d", len(got), err, len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("1500 bytes at 1000 B/s took %s, want at least 0.5s", elapsed)
+	}
+
+	// Without a bandwidth the reader doesn't wait
+	start = time.Now()
+	if got, err := io.ReadAll(NewThrottledReader(context.Background(), bytes.NewReader(data), NewLimiter(0))); err != nil || len(got) != len(data) {
+		t.Fatalf("read %d bytes, %v without a limit, want %d", len(got), err, len(data))
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("reading without a limit took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := io.ReadAll(NewThrottledReader(ctx, bytes.NewReader(data), NewLimiter(1000))); err != context.Canceled {
+		t.Errorf("reading with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
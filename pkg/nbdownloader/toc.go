@@ -0,0 +1,294 @@
+package nbdownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// headerBand is the part of the page height searched for running headers
+const headerBand = 0.05
+
+// maxHeaderDistance is the largest edit distance, relative to the length of
+// the longer text, at which two headers are considered the same. It absorbs
+// OCR errors between pages.
+const maxHeaderDistance = 0.2
+
+// bookTitleShare is the share of headed pages above which a header is taken
+// to be the book title, which is repeated on every other page, rather than a
+// chapter title
+const bookTitleShare = 0.4
+
+// chapter is a section of a book found from its running headers
+type chapter struct {
+	title string
+	start int // index of the first page in the page list
+}
+
+// findTesseract returns the OCR engine used to read running headers
+func findTesseract() (string, error) {
+	path, err := exec.LookPath("tesseract")
+	if err != nil {
+		return "", errors.New("tesseract not found in PATH, it is needed to read the page headers")
+	}
+	return path, nil
+}
+
+// ocrBand runs OCR on a horizontal band of a page image, from top to
+// bottom given as fractions of the page height, and returns the text found
+// there. The band is saved next to the page as <page>_<name>.jpg while
+// tesseract reads it.
+func ocrBand(ctx context.Context, tesseract, path, name string, top, bottom float64) (string, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return "", err
+	}
+	bounds := img.Bounds()
+	band := bounds
+	band.Min.Y = bounds.Min.Y + int(float64(bounds.Dy())*top)
+	band.Max.Y = max(bounds.Min.Y+int(float64(bounds.Dy())*bottom), band.Min.Y+1)
+
+	// image/jpeg can only encode whole images, so the band is copied
+	crop := image.NewRGBA(band)
+	for y := band.Min.Y; y < band.Max.Y; y++ {
+		for x := band.Min.X; x < band.Max.X; x++ {
+			crop.Set(x, y, img.At(x, y))
+		}
+	}
+	bandPath := strings.TrimSuffix(path, filepath.Ext(path)) + "_" + name + ".jpg"
+	if err := saveJPEG(bandPath, crop); err != nil {
+		return "", err
+	}
+	defer os.Remove(bandPath)
+
+	// Page segmentation mode 7 treats the band as a single line of text
+	return runTesseract(ctx, tesseract, bandPath, "--psm", "7")
+}
+
+// runTesseract runs OCR on an image file and returns the recognized text
+func runTesseract(ctx context.Context, tesseract, path string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, tesseract, append([]string{path, "stdout"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("tesseract failed: %w\n%s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
+
+// readHeader returns the running header of a page, read from the top
+// headerBand of the image
+func readHeader(ctx context.Context, tesseract, path string) (string, error) {
+	text, err := ocrBand(ctx, tesseract, path, "header", 0, headerBand)
+	if err != nil {
+		return "", err
+	}
+	return cleanHeader(text), nil
+}
+
+// cleanHeader removes the page number and stray punctuation from a running
+// header and collapses its whitespace
+func cleanHeader(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsDigit(r) || r == '|' || r == '_'
+	})
+	return strings.Trim(strings.Join(words, " "), " .,:;-–—")
+}
+
+// sameHeader reports whether two headers are the same text apart from OCR
+// errors
+func sameHeader(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return true
+	}
+	return float64(editDistance(ra, rb)) <= maxHeaderDistance*float64(longest)
+}
+
+// editDistance returns the Levenshtein distance of two strings
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// findChapters groups pages by their running header. headers holds the
+// cleaned header of each page, "" for none. A header counts only if it
+// recurs within a few pages, which filters out OCR noise and body text
+// caught in the band. Headers found on a large share of the pages are
+// the book title and are ignored. A chapter starts at its first headed page,
+// or at a page without running header just before it, since chapter opening
+// pages usually carry none. Of the OCR variants of a header the most frequent one
+// becomes the chapter title.
+func findChapters(headers []string) []chapter {
+	const lookahead = 4 // running headers often appear only on every other page
+
+	running := make([]string, len(headers))
+	headed := 0
+	for i, h := range headers {
+		if h == "" {
+			continue
+		}
+		for j := max(i-lookahead, 0); j <= min(i+lookahead, len(headers)-1); j++ {
+			if j != i && headers[j] != "" && sameHeader(h, headers[j]) {
+				running[i] = h
+				headed++
+				break
+			}
+		}
+	}
+
+	// Count how many pages each distinct header appears on
+	var distinct []string
+	var counts []int
+	for _, h := range running {
+		if h == "" {
+			continue
+		}
+		found := false
+		for k, d := range distinct {
+			if sameHeader(h, d) {
+				counts[k]++
+				found = true
+				break
+			}
+		}
+		if !found {
+			distinct = append(distinct, h)
+			counts = append(counts, 1)
+		}
+	}
+	isTitle := func(h string) bool {
+		for k, d := range distinct {
+			if sameHeader(h, d) {
+				return len(distinct) > 1 && float64(counts[k]) > bookTitleShare*float64(headed)
+			}
+		}
+		return false
+	}
+
+	var chapters []chapter
+	var variants []map[string]int // OCR variants of each chapter's header
+	lastHeaded := -1              // last page of the current chapter with its header
+	for i, h := range running {
+		if h == "" || isTitle(h) {
+			continue
+		}
+		if n := len(chapters); n > 0 && sameHeader(h, chapters[n-1].title) {
+			variants[n-1][h]++
+			lastHeaded = i
+			continue
+		}
+
+		// The opening page may be followed by a page with the book title. Its
+		// first line may be read as a header that does not recur.
+		start := i
+		for j := i - 1; j > lastHeaded && j >= i-2; j-- {
+			if running[j] == "" {
+				start = j
+				break
+			}
+		}
+		chapters = append(chapters, chapter{title: h, start: start})
+		variants = append(variants, map[string]int{h: 1})
+		lastHeaded = i
+	}
+
+	for n := range chapters {
+		for v, count := range variants[n] {
+			if best := chapters[n].title; count > variants[n][best] || count == variants[n][best] && v < best {
+				chapters[n].title = v
+			}
+		}
+	}
+	return chapters
+}
+
+// detectChapters reads the running headers of the pages and returns the
+// chapters they indicate
+func (b *Book) detectChapters(ctx context.Context, tesseract string, pages []string) ([]chapter, error) {
+	headers := make([]string, len(pages))
+	for i, page := range pages {
+		fmt.Fprintf(b.log, "\rReading page headers: %d/%d", i+1, len(pages))
+		header, err := readHeader(ctx, tesseract, page)
+		if err != nil {
+			fmt.Fprintln(b.log)
+			return nil, fmt.Errorf("error reading header of %s: %w", filepath.Base(page), err)
+		}
+		headers[i] = header
+	}
+	fmt.Fprintln(b.log)
+
+	chapters := findChapters(headers)
+	fmt.Fprintf(b.log, "Found %d chapters from running headers\n", len(chapters))
+	return chapters, nil
+}
+
+// Chapter is a chapter of a book found from the running headers in its
+// page text
+type Chapter struct {
+	Title string     // "" for the pages before the first chapter
+	Pages []PageText // in page order
+}
+
+// TextChapters groups the page texts in dir, as saved by -ocr-text, into
+// chapters. The first line of a page's text is taken as its running header,
+// and the headers are grouped as -gen-toc-from-headers does with headers read
+// from the page images. It returns nil if no chapters were found.
+func TextChapters(dir string) ([]Chapter, error) {
+	pageIDs, raw, err := readPageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]string, len(raw))
+	pages := make([]PageText, len(raw))
+	for i, text := range raw {
+		for _, line := range strings.Split(text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				headers[i] = cleanHeader(line)
+				break
+			}
+		}
+		pages[i] = PageText{Page: displayPage(pageIDs[i]), Text: strings.Join(strings.Fields(text), " ")}
+	}
+
+	found := findChapters(headers)
+	if len(found) == 0 {
+		return nil, nil
+	}
+	var chapters []Chapter
+	if found[0].start > 0 {
+		chapters = append(chapters, Chapter{Pages: pages[:found[0].start]})
+	}
+	for n, c := range found {
+		end := len(pages)
+		if n+1 < len(found) {
+			end = found[n+1].start
+		}
+		chapters = append(chapters, Chapter{Title: c.title, Pages: pages[c.start:end]})
+	}
+	return chapters, nil
+}
@@ -0,0 +1,36 @@
+package nbdownloader
+
+import "runtime/debug"
+
+// modulePath is the module this package belongs to
+const modulePath = "github.com/alcxyz/NB.no-Downloader"
+
+// version is set at build time with
+// -ldflags "-X github.com/alcxyz/NB.no-Downloader/pkg/nbdownloader.version=..."
+var version string
+
+// Version returns the version of the library, e.g. "v1.4.0". Unless it was
+// set at build time it is the module version Go recorded in the binary, which
+// is "(devel)" or empty for builds from a source checkout without VCS
+// information.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}